@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Find the path given with -config among the command line arguments, so that the
+// config file can be read before the rest of the flags are parsed
+func configPath(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config=")
+		}
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// Set flags from a config file, which maps flag names to their values. A .json file is
+// read as a JSON object; anything else is read as simple YAML, with one "name: value" per line
+// and # for comments. Flags on the command line are parsed afterwards, so they take precedence.
+func loadConfig(path string) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var settings map[string]string
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		settings, err = parseJSONConfig(contents)
+	} else {
+		settings, err = parseYAMLConfig(contents)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for name, value := range settings {
+		if name == "config" {
+			continue
+		}
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s: bad value for %s: %v", path, name, err)
+		}
+	}
+	return nil
+}
+
+// Read a config file in JSON, where values may be strings, numbers or booleans
+func parseJSONConfig(contents []byte) (map[string]string, error) {
+	var raw map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+	settings := map[string]string{}
+	for name, value := range raw {
+		switch value.(type) {
+		case string, bool, json.Number:
+			settings[name] = fmt.Sprint(value)
+		default:
+			return nil, fmt.Errorf("%s should be a string, number or true/false", name)
+		}
+	}
+	return settings, nil
+}
+
+// Read a config file in the simple subset of YAML used for settings: one "name: value"
+// per line, with optional quotes around the value, and blank lines or lines starting with # ignored
+func parseYAMLConfig(contents []byte) (map[string]string, error) {
+	settings := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	line_number := 0
+	for scanner.Scan() {
+		line_number++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			return nil, fmt.Errorf("line %d: expected name: value", line_number)
+		}
+		name := strings.TrimSpace(line[:colon])
+		value := strings.TrimSpace(line[colon+1:])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		settings[name] = value
+	}
+	return settings, scanner.Err()
+}
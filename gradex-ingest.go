@@ -1,568 +1,441 @@
 //
 // ingest exam submissions from different sources and rename all output files consistently
 //
+// the work is done by the ingest package, so that it can also be run from other programs (or tests);
+// this command reads the flags into an ingest.IngestOptions and sets the exit code from the result
+//
 // usage:
 //
-//  gradex-ingest -deadline=2020-04-22-16-00 -classlist=MATH00000_enrolment.csv learndir=MATH00000 outputdir=MATH00000_examno
+//  gradex-ingest -course=MATH00000 -deadline=2020-04-22-16-00 -classlist=MATH00000_enrolment.csv learndir=MATH00000 outputdir=MATH00000_examno [other folders]
+//
+//  * config (optional) is a YAML or JSON file giving any of the flags, as "name: value" lines (YAML) or an object (JSON),
+//    so each diet's settings can be kept together; flags given on the command line override the file
+//  * classlist is a csv that should have columns: UUN, Exam Number, Extra Time (giving the number of minutes allowed, or a time with units such as 90m or 1.5h)
+//    and optionally Deadline (in the same form as the deadline flag), which replaces the normal deadline and extra time for that student,
+//    and Status, where students marked Withdrawn are left out and listed in learn-withdrawn.csv,
+//    and Group, for exams with several sittings, where groupdeadlines (a csv with columns Group, Deadline) gives each group's deadline
+//    (students in a group with no deadline there have the normal deadline; extra time is added to either)
+//    (extra time granted after the class list was frozen can be given with concessions, a csv with columns UUN, Extra Time, Note,
+//    which replaces the class list's extra time for those students; any submission that is on time only because of it is logged)
+//    (if the class list has different column names, give them with col-uun, col-examno and col-extratime; a column named
+//    with col-extratime has to be there, so that a mistyped name doesn't leave everyone with no extra time)
+//    (if the exam numbers are kept in a separate csv, with columns UUN and Exam Number, give it with examnomap)
+//    (for courses without exam numbers, hashsalt gives each student with none an anonymous one, the first 10 hex digits of an
+//    HMAC-SHA256 of their UUN keyed with the salt, so the same salt gives the same numbers on every run; keep the salt secret,
+//    since anyone with it and the class list can work out whose each file is)
+//    (several class lists can be given, separated by commas, and they will be merged)
+//    (for practice runs without a class list, noclasslist processes everyone with a Learn receipt, using their UUN as the exam number,
+//    or the salted hash of it with hashsalt)
+//  * deadline (as 2020-04-22-16-00, or in RFC3339 form like 2020-04-22T16:00:00+01:00) is used to determine which submissions are late (also taking account of allowance for extra time from classlist)
+//    (with no deadline, i.e. -deadline= or -deadline=none, nothing is late, and the reports give LateSubmission as "Not evaluated")
+//  * timezone (optional, default the system's local time zone) is the zone that the deadline and Learn submission times are in, e.g. Europe/London
+//  * hardcutoff (optional) rejects submissions made this long after the student's deadline and extra time, removing them and listing them in learn-rejected.csv
+//  * minage (optional, e.g. 30s) leaves alone any student with a receipt or submitted file modified more recently than this,
+//    since Learn may still be writing it; they are listed in learn-stillbeingwritten.csv, and the run needs attention until
+//    a later run has picked them up
+//  * grace is added to the deadline (default 59s, so 16:00 means anything up to 16:00:59 is on time); a student's extra time is added on top of the grace period
+//  * learndir should be the path to the folder containing the unzipped export from Learn, or to the zip (or .tar.gz/.tgz) file itself
+//    (which is unzipped to a temporary folder, deleted at the end unless keeptemp is set)
+//  * other folders given after the flags are read in the same way as learndir, so that exports split across several folders are all considered
+//  * turnitindir (optional) is a folder exported from Turnitin, indexed by turnitincsv (default turnitindir/index.csv) with columns
+//    Student ID, Paper ID, Date Uploaded, File Name; these are treated like Learn submissions, and labelled Turnitin in the success report
+//  * formsdir (optional) is a folder of files uploaded to MS Forms, listed in formscsv with columns UUN, Filename; these are used for students with no Learn submission
+//  * outputdir should be the path where the anonymised scripts will be placed; reports on each run go in outputdir/reports/<timestamp>
+//    (including examno_to_uun.csv, which maps the exam numbers of the scripts back to UUNs once marking is done,
+//    and learn-submissiontimes.csv, which counts how close to the deadline the submissions used were made)
+//  * template sets the output file names (default {{.Course}}_{{.ExamNumber}}.pdf), using the fields Course, ExamNumber, UUN, Late and Sequence;
+//    the extension is always taken from what is in the file (so .pdf), whatever the template or the student's file name ends in
+//    (Sequence numbers the students in order of exam number, padded with zeros to suit the class size; -sequence puts it at the start of every name)
+//  * overrides (optional) is a csv with columns UUN, File giving the path of a file to use for particular students, e.g. after
+//    sorting out a bad submission by hand; it is used whatever else they submitted, and labelled Override in the success report.
+//    The file is copied, always replacing the output file (even with nooverwrite), and is left where it is for later runs
+//  * sortby (default uun) puts the students in order of UUN or exam number (examno), so that the reports from different runs line up
+//  * accommodations (optional) lists the UUNs of students with an accommodation (as for only); any of them with no extra time in
+//    the class list who have a LATE submission are warned about, as their extra time may have been left out by mistake
+//  * only (optional) restricts the run to the given UUNs (a comma-separated list or a file), for re-running individual students
+//  * simulatedeadlines (optional) is a comma-separated list of deadlines to try; for each, the number of students who would be
+//    on time or late is printed, and nothing is moved, deleted or written (so a resit deadline can be chosen with care)
+//  * reportsonly writes the reports again from the output files already in outputdir and the class list, without moving,
+//    copying or deleting any file, e.g. to replace reports that have been lost once marking has started
+//  * checkreceipt (optional) just reads the one Learn receipt given, printing the fields found in it and any problems
+//    (such as a date in a form that isn't understood, or a listed file that is missing), and stops
+//  * validateonly just checks the class list (duplicate UUNs or exam numbers, missing fields, bad extra time) and stops
+//  * submissions from UUNs that are not in any class list are left where they are and listed in learn-unknownstudent.csv
+//  * receipts that name a different UUN from the one in their file name are left where they are and listed in learn-uunmismatch.csv
+//  * students whose submitted files are byte-for-byte identical are listed together in learn-duplicates.csv, to be checked
+//  * nooverwrite never replaces an existing output file; a submission that differs from it (whatever its age) is left in place
+//    and listed in learn-conflicts.csv, and one that is the same is removed as already done
+//    (and not in learn-success.csv, since the existing file is the one to be marked)
+//  * coversheet adds a page to the start of each new output file giving the exam number, the number of pages,
+//    when it was submitted and whether it was late (but not the UUN), leaving the submitted pages after it unchanged
+//  * stripmetadata rewrites each output PDF without its document information (Author, Title etc.), which can name the student
+//  * outputmode sets the permissions of the output files and reports (default 0640)
+//  * inplace renames each submission within the folder it was found in, rather than moving it to outputdir;
+//    the reports still go in outputdir, with manifest.csv mapping the original files to their new names. Later runs look for
+//    the output files in the input folders too. It can't be used when learndir is a zip or tar.gz file
+//  * reportformat (default csv) writes the reports as csv, tsv (tab-separated) or json (an array of objects, one per row, keyed by the column names)
+//  * anonymisereceipts (with keepreceipts) moves the kept receipts into outputdir/receipts, named after the output file,
+//    so they don't show the UUN; .txt files in learndir not named like a Learn receipt are ignored
+//  * keeplate moves late submissions that aren't used (and those beyond the hardcutoff) into outputdir/late, with their receipts,
+//    named after the output file and the time submitted, so they are kept for any appeal but are not in with the scripts to mark
+//  * copyonly leaves learndir untouched, so the same Learn download can be processed repeatedly
+//
+// exit codes:
+//
+//  0 - all clean
+//  1 - finished, but some submissions (or class list rows) need attention - see the reports
+//      (this includes any student left for a later run with -minage, whose files were still being written)
+//      (with -strict, this includes any student with no submission, or an empty one)
+//      (with -requireclean, this includes any files left in learndir or the other folders)
+//  2 - stopped part way through, once files had started to be moved (e.g. the reports could not be written),
+//      or crashed
+//  3 - could not start, e.g. because of a bad flag (including one not recognised), config file or class list
 //
-//  * classlist is a csv that should have columns: UUN, Exam Number, Extra Time (giving the number of minutes allowed)
-//  * deadline is used to determine which submissions are late (also taking account of allowance for extra time from classlist)
-//  * learndir should be the path to the folder containing the unzipped export from Learn
-//  * outputdir should be the path where the anonymised scripts will be placed
+// the last line printed (unless the run could not start) is a summary for scripts to read, of the form
+//
+//  SUMMARY version=1 successful=120 manual=1 bad=4 wrongformat=0 empty=1 none=7 alreadydone=0 rejected=0 conflicts=0 unknown=0 uunmismatch=0 duplicates=0 missingexamno=0 withdrawn=2 attention=true stillbeingwritten=0
+//
+// where the counts are of students (or receipts, for unknown and uunmismatch); fields may be added at the end,
+// but those there will not be renamed or reordered without changing the version
 //
 // workflow:
 //
-//  1. Unzip the Learn download into learndir, and run the above command.
+//  1. Unzip the Learn download into learndir (or give the zip file as learndir), and run the above command.
 //  2. Any bad submissions will be left in the learndir. Manually inspect these and where possible, replace all the Learn files for a submission with a single file called "uun.pdf" (where uun is the student's UUN, e.g. s1234567).
-//  3. Re-run the above command. This will process the "uun.pdf" files.
+//  3. Re-run the above command. This will process the "uun.pdf" files. Add -resume to leave alone any students whose output file is already in place.
 //
 
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"time"
-	"flag"
-	"io"
-	"regexp"
-	"encoding/json"
 
-	"github.com/gocarina/gocsv"
-	"github.com/georgekinnear/parselearn"
+	"github.com/georgekinnear/gradex-ingest/ingest"
 )
 
-// Structure for the class list csv
-type Students struct {
-	StudentID       string  `csv:"UUN"`
-	ExamNumber      string  `csv:"Exam Number"`
-	ExtraTime      	int     `csv:"Extra Time"`
-}
-
-/*
-type SubmissionSummary struct {
-	UUN			       string  `csv:"UUN"`
-	ExamNumber         string  `csv:"ExamNumber"`
-	DateSubmitted      string  `csv:"DateSubmitted"`
-	LateSubmission     string  `csv:"LateSubmission"`
-	ExtraTime	       int     `csv:"ExtraTime"`
-	Filename           string  `csv:"Filename"`
-	NumberOfFiles      int     `csv:"NumberOfFiles"`
-}
-*/
+// Exit codes, so that scripts running this can tell how it went
+// (Go itself also exits with code 2 if the program crashes, which is also a run stopped part way through)
+const (
+	exitClean      = 0 // every student was dealt with
+	exitProblems   = 1 // there are bad submissions, or other problems in the reports needing attention
+	exitFailed     = 2 // the run stopped part way through, e.g. because the reports could not be written
+	exitSetupError = 3 // the run could not start, e.g. because of a bad flag or class list
+)
 
 func main() {
 
+	// A bad flag is a setup error like any other, rather than the exit code 2 that flag.Parse would give it
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+
 // Check arguments
 
     var courseCode string
     flag.StringVar(&courseCode, "course", "MATH00000", "the course code, will be prepended to output file names")
 	
 	var classListCSV string
-    flag.StringVar(&classListCSV, "classlist", "MATH00000_enrolment.csv", "csv file containing the student UUN, Exam Number and number of minutes of extra time they are entitled to")
+    flag.StringVar(&classListCSV, "classlist", "MATH00000_enrolment.csv", "csv file containing the student UUN, Exam Number and number of minutes of extra time they are entitled to (several files can be given, separated by commas)")
 	
 	var learnDir string
-    flag.StringVar(&learnDir, "learndir", "learn_dir", "path of the folder containing the unzipped Learn download")
+    flag.StringVar(&learnDir, "learndir", "learn_dir", "path of the folder containing the unzipped Learn download, or of the Learn zip (or .tar.gz) file itself")
 	
 	var outputDir string
     flag.StringVar(&outputDir, "outputdir", "output_dir", "path of the folder where output files should go")
 	
+	var colUUN string
+    flag.StringVar(&colUUN, "col-uun", "UUN", "name of the class list column holding the student's UUN")
+	
+	var colExamNo string
+    flag.StringVar(&colExamNo, "col-examno", "Exam Number", "name of the class list column holding the student's exam number")
+	
+	var colExtraTime string
+    flag.StringVar(&colExtraTime, "col-extratime", "Extra Time", "name of the class list column holding the student's minutes of extra time")
+	
 	var deadline string
-    flag.StringVar(&deadline, "deadline", "2020-04-22-16-00", "date and time of the normal submission deadline")
+    flag.StringVar(&deadline, "deadline", "2020-04-22-16-00", "date and time of the normal submission deadline, as 2020-04-22-16-00 or 2020-04-22T16:00:00+01:00, or none to not check lateness")
 	
-	debuggingMode := flag.Bool("debug", false, "print extra details for debugging? (true/false)")
+	var timeZoneName string
+    flag.StringVar(&timeZoneName, "timezone", "", "time zone of the deadline and the times on the Learn receipts, e.g. Europe/London (default the system's local time zone)")
 	
-	flag.Parse()
-
-	deadline_time, e := time.Parse("2006-01-02-15-04", deadline)
-	check(e)
+	var gracePeriod time.Duration
+    flag.DurationVar(&gracePeriod, "grace", 59*time.Second, "grace period added to the deadline before submissions count as late (e.g. 30s, 5m, 0s); extra time from the classlist is added on top of this")
 	
-	// Add 59 seconds to the deadline, so that a deadline of 12:00 means submissions up to 12:00:59 are on time but 12:01:00 is late
-	deadline_time = deadline_time.Add(time.Second * time.Duration(59))
+	var hardCutoff time.Duration
+    flag.DurationVar(&hardCutoff, "hardcutoff", 0, "submissions this long after the student's deadline (e.g. 168h for 7 days) are rejected and removed, even with -acceptlate (0 for no cutoff)")
 	
-	fmt.Println("course: ", courseCode)
-	fmt.Println("deadline: ", deadline_time.Format("2006-01-02 at 15:04:05"))	
-	fmt.Println("learn folder: ", learnDir)
-	fmt.Println("other folders to read: ", flag.Args())
+	var concessions string
+    flag.StringVar(&concessions, "concessions", "", "csv file with columns UUN, Extra Time, Note giving extra time granted since the class list was made, which replaces the class list's")
 	
-	// Check the output directory exists, and if not then make it
-	err := ensureDir(outputDir)
-	if err != nil {
-		os.MkdirAll(outputDir, os.ModePerm)
-	}
-	err = ensureDir(outputDir)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
+	var groupDeadlines string
+    flag.StringVar(&groupDeadlines, "groupdeadlines", "", "csv file with columns Group, Deadline giving the deadline for each group in the class list's Group column (e.g. for a second sitting)")
 	
-	// Check that the input folder exists
-	err = ensureDir(learnDir)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
+	var since string
+    flag.StringVar(&since, "since", "", "only read Learn receipts modified after this date and time (same form as deadline), for incremental runs")
 	
-	// Parse the class list
-	fmt.Println("class list csv: ", classListCSV)
-	classListFile, err := os.OpenFile(classListCSV, os.O_RDWR|os.O_CREATE, os.ModePerm)
-	if err != nil {
-		fmt.Println("File: ",classListFile, err)
-		panic(err)
-	}
-	defer classListFile.Close()
-
-	classlist_raw := []Students{}
-	if err := gocsv.UnmarshalFile(classListFile, &classlist_raw); err != nil {
-		panic(err)
-	}
-	// Make this into a map with UUNs as keys
-	classlist := map[string]Students{}
-	for _, s := range classlist_raw {
-		s.StudentID = strings.ToUpper(s.StudentID)
-		if !strings.HasPrefix(s.StudentID, "S") {
-			// prepend an "S" to the UUN if not there already in the classlist csv
-			s.StudentID = "S"+s.StudentID
-		}
-		classlist[s.StudentID] = s
-	}
+	var minAge time.Duration
+    flag.DurationVar(&minAge, "minage", 0, "skip students with receipts or submitted files modified less than this long ago (e.g. 30s), which may still be being written (0 to read everything)")
 	
-	fmt.Println("class list contains ", len(classlist), "students")
-	if *debuggingMode {
-		PrettyPrintStruct(classlist)
-	}
+	var minBytes int64
+    flag.Int64Var(&minBytes, "minbytes", 1024, "submitted files smaller than this many bytes are treated as bad submissions")
 	
+	var auditLogPath string
+    flag.StringVar(&auditLogPath, "auditlog", "", "csv file to add a record of every file moved or deleted to (time, operation, source, destination, result)")
 	
-	// regex to read the UUN that appears in the Learn files
-	finduun, _ := regexp.Compile("_(s[0-9]{7})_attempt_")
-
-
-	// Build map of UUN to a slice of Learn submissions
-	var learn_files = map[string][]parselearn.Submission{}
-	var num_learn_files int
-	filepath.Walk(learnDir, func(path string, f os.FileInfo, _ error) error {
-		if !f.IsDir() {
-			r, err := regexp.MatchString(".txt", f.Name())
-			if err == nil && r {
-				//fmt.Println(f.Name())
-				extracted_uun := strings.ToUpper(finduun.FindStringSubmatch(f.Name())[1])
-				
-				// read the Learn receipt file
-				submission, err := parselearn.ParseLearnReceipt(learnDir+"/"+f.Name())
-				check(err)
-				submission.ExamNumber = classlist[extracted_uun].ExamNumber
-				submission.ExtraTime = classlist[extracted_uun].ExtraTime
-				submission.ReceiptFilename = f.Name()
-				
-				// Decide if the submission is LATE or not
-				sub_time, _ := time.Parse("2006-01-02-15-04-05", submission.DateSubmitted)
-				if(sub_time.After(deadline_time)) {
-					if(submission.ExtraTime > 0) {
-						// For students with extra time noted in the class list, their submission deadline is shifted
-						if(sub_time.After(deadline_time.Add(time.Minute * time.Duration(submission.ExtraTime)))) {
-							submission.LateSubmission = "LATE"
-						}
-					} else {
-						// For students with no allowance of extra time, their submission is marked late
-						submission.LateSubmission = "LATE"
-					}
-				}
-				
-				// If there are already submissions from this student, add them to the list; otherwise start a new list
-				if _, ok := learn_files[extracted_uun]; ok {
-					learn_files[extracted_uun] = append(learn_files[extracted_uun], submission)					
-				} else {
-					learn_files[extracted_uun] = []parselearn.Submission{submission}
-				}
-				num_learn_files++
-			}
-			}
-		return nil
-	})
-	fmt.Println("learn files: ",num_learn_files, "from", len(learn_files), "students")
-	if *debuggingMode {
-		PrettyPrintStruct(learn_files)
-	}
-		
-/*	
-	// Read the class list csv	
-	csvfile, err := os.Open(classListCSV)
-	if err != nil {
-		log.Fatalln("Couldn't open the csv file", err)
-	}
-	classlistcsv := csv.NewReader(csvfile)
+	var overrides string
+    flag.StringVar(&overrides, "overrides", "", "csv file with columns UUN, File giving the file to use for particular students, instead of anything they submitted")
 	
-	var examno = map[string]string{}
-*/
-
-	// Prepare data structures to hold the data
-	var submissions []parselearn.Submission
-	var bad_submissions []parselearn.Submission
-	var no_submissions []parselearn.Submission
-	var submission_summaries []parselearn.Submission
-
-	//
-	// Identify the submission for each student in the class list
-	//
-	for _, student := range classlist {
-		
-		student_uun := student.StudentID
-		if !strings.HasPrefix(student_uun, "S") {
-			// prepend an "S" to the UUN if not there already in the classlist csv
-			student_uun = "S"+student_uun
-		}
-		student_examno := student.ExamNumber
-		extratime := student.ExtraTime
-		
-		// Check their submissions to Learn
-		if student_submissions, ok := learn_files[student_uun]; ok {
-			fmt.Printf("%s -> %s (extra time: %d)\n", student_uun, student_examno, extratime)
-			
-			// Find the last non-LATE submission among student_submissions
-			submission := parselearn.Submission{}
-			submission.DateSubmitted = "2000-01-01-12-00-00" // a dummy time well in the past
-			submission_time, _ := time.Parse("2006-01-02-15-04-05", submission.DateSubmitted)
-			submission.LateSubmission = "LATE" // this will appear in the report if there are no on-time submissions
-			for _, sub := range student_submissions {
-				if sub.LateSubmission == "LATE" {
-					// skip any LATE submissions
-					fmt.Println(" -- Skipped LATE submission: ", sub.ReceiptFilename)
-					sub.ToMark = "No - LATE"
-					submission_summaries = append(submission_summaries, sub)
-					removeFile(learnDir+"/"+sub.ReceiptFilename)
-					if sub.Filename != "" {
-						removeFile(learnDir+"/"+sub.Filename)
-					}
-					continue
-				}
-				sub_time, _ := time.Parse("2006-01-02-15-04-05", sub.DateSubmitted)
-				if sub_time.After(submission_time) {
-					// submission is superseded by sub - so remove files for submission
-					if submission.ReceiptFilename != "" {
-						fmt.Println(" -- Skipped submission: ", submission.ReceiptFilename)
-						submission.ToMark = "No - Superseded"						
-						submission_summaries = append(submission_summaries, submission)
-						removeFile(learnDir+"/"+submission.ReceiptFilename)
-						if submission.Filename != "" {
-							removeFile(learnDir+"/"+submission.Filename)
-						}
-					}
-					// update submission with the more recent sub
-					submission = sub
-					submission_time = sub_time
-				}				
-			}
-			
-			// If a student's earliest submission is LATE, note that fact
-			if submission.LateSubmission == "LATE" {
-				fmt.Println(" --- No on-time submission.")
-				bad_submissions = append(bad_submissions, student_submissions[0])
-				continue
-			}
-			
-			if submission.NumberOfFiles == 1 && submission.FiletypeError == "" {
-			
-				// We have one PDF for the student, so move it into place in the outputDir
-				
-				fmt.Println(" -- Using Submission:   ",submission.Filename)
-				submission.ToMark = "Yes"
-				submission_summaries = append(submission_summaries, submission)
-				new_path := outputDir+"/"+student_examno+".pdf"
-				if (submission.LateSubmission == "LATE") {
-					new_path = outputDir+"/LATE-"+student_examno+".pdf"
-				}
-				filemovestatus := moveFile(learnDir+"/"+submission.Filename, new_path)
-				submission.OutputFile = filemovestatus
-				fmt.Println(" --- ", filemovestatus)
-				
-				// If the file move was OK, we can remove the Learn receipt as it's no longer needed
-				if(strings.Contains(filemovestatus, "File")) {
-					removeFile(learnDir+"/"+submission.ReceiptFilename)
-				}
-				
-				// Add this record to the table of successes
-				submissions = append(submissions, submission)
-				
-			} else {
-				// There was a problem with this submission, so it will need investigation and manual work
-				
-				fmt.Println(" --- Bad submission: ",submission.NumberOfFiles, " files ", submission.FiletypeError)
-				submission.ToMark = "Bad submission"
-				submission_summaries = append(submission_summaries, submission)
-				bad_submissions = append(bad_submissions, submission)					
-			}
-			
-			// Done - move on to next student
-			continue
-		}
-		
-		// At this point they did not submit to Learn - check for a raw UUN.pdf
-		raw_uun_path := learnDir+"/"+strings.ToLower(student_uun)+".pdf"
-		if _, err := os.Stat(raw_uun_path); err == nil {
-			// Such a file exists, so create a dummy Submission for it and then move the PDF into place
-			manual_sub := parselearn.Submission{}
-			manual_sub.UUN = student_uun
-			manual_sub.ExamNumber = student_examno
-			filemovestatus := moveFile(raw_uun_path, outputDir+"/"+student_examno+".pdf")
-			manual_sub.OutputFile = filemovestatus
-			manual_sub.LateSubmission = "Manual"
-			submissions = append(submissions, manual_sub)
-			
-			// Done - move on to next student
-			continue
-		}
-		
-		// Now there is really no submission from this student, so record that fact
-		sub := parselearn.Submission{}
-		sub.UUN = student_uun
-		sub.ExamNumber = student_examno
-		sub.NumberOfFiles = 0
-		no_submissions = append(no_submissions, sub)
+	var examNoMap string
+    flag.StringVar(&examNoMap, "examnomap", "", "csv file with columns UUN, Exam Number, giving the exam numbers of students whose class list entry has none")
 	
-	}
+	var hashSalt string
+    flag.StringVar(&hashSalt, "hashsalt", "", "secret used to make an anonymous exam number from the UUN of each student who has none (the same salt always gives the same numbers)")
 	
+	var accommodations string
+    flag.StringVar(&accommodations, "accommodations", "", "UUNs of students with an accommodation (a comma-separated list or a file), to warn about if they are LATE with no extra time in the class list")
 	
+	var onlyUUNs string
+    flag.StringVar(&onlyUUNs, "only", "", "only process these students, given as a comma-separated list of UUNs or a file of UUNs; everyone else is left untouched")
 	
+	var turnitinDir string
+    flag.StringVar(&turnitinDir, "turnitindir", "", "path of a folder exported from Turnitin, whose submissions are used alongside the Learn ones")
 	
-	/*
+	var turnitinCSV string
+    flag.StringVar(&turnitinCSV, "turnitincsv", "", "csv file with columns Student ID, Paper ID, Date Uploaded, File Name indexing the files in turnitindir (default turnitindir/index.csv)")
 	
+	var formsDir string
+    flag.StringVar(&formsDir, "formsdir", "", "path of a folder of files uploaded to MS Forms, used when a student has no Learn submission")
 	
+	var formsCSV string
+    flag.StringVar(&formsCSV, "formscsv", "", "csv file with columns UUN, Filename listing the files in formsdir (default formsdir/forms.csv)")
 	
+	var sortBy string
+    flag.StringVar(&sortBy, "sortby", "uun", "order of the students in the reports and output: uun or examno")
 	
-		
-		// check the Learn folder
-		if learn_file, ok := learn_files[student_uun]; ok {
-			fmt.Println(" - Learn file: ",learn_file)
-
-			// read the Learn receipt file
-			submission, err := parselearn.ParseLearnReceipt(learnDir+"/"+learn_file[0])
-			submission.ExamNumber = student_examno
-			submission.ExtraTime = extratime_int
-			
-			// Decide if the submission is LATE or not
-			sub_time, _ := time.Parse("2006-01-02-15-04-05", submission.DateSubmitted)
-			if(sub_time.After(deadline_time)) {
-				if(extratime_int > 0) {
-					// For students with extra time noted in the class list, their submission deadline is shifted
-					if(sub_time.After(deadline_time.Add(time.Minute * time.Duration(extratime_int)))) {
-						submission.LateSubmission = "LATE"
-					}
-				} else {
-					// For students with no allowance of extra time, their submission is marked late
-					submission.LateSubmission = "LATE"
-				}
-			}
-			
-			if err == nil {
-				if submission.NumberOfFiles == 1 && submission.FiletypeError == "" {
-				
-					// We have one PDF for the student, so move it into place in the outputDir
-					
-					fmt.Println(" -- Submission: ",submission.Filename)
-					new_path := outputDir+"/"+student_examno+".pdf"
-					if (submission.LateSubmission == "LATE") {
-						new_path = outputDir+"/LATE-"+student_examno+".pdf"
-					}
-					filemovestatus := moveFile(learnDir+"/"+submission.Filename, new_path)
-					submission.OutputFile = filemovestatus
-					fmt.Println(" --- ", filemovestatus)
-					
-					// If the file move was OK, we can remove the Learn receipt as it's no longer needed
-					if(strings.Contains(filemovestatus, "File")) {
-						removeFile(learnDir+"/"+learn_file[0])
-					}
-					
-					// Add this record to the table of successes
-					submissions = append(submissions, submission)
-					
-				} else {
-					// There was a problem with this submission, so it will need investigation and manual work
-					
-					fmt.Println(" --- Bad submission: ",submission.NumberOfFiles, " files ", submission.FiletypeError)
-					bad_submissions = append(bad_submissions, submission)					
-				}
-			} else {
-				fmt.Printf("Error with %s: %v\n", learn_file, err)
-			}
-			
-		} else {
-			// No Learn submission from this student -- check for other sources
-			
-			// TODO - process for reading in submissions to MS Forms
-			
-			
-			// Last resort: look for manually-created UUN.pdf in the learnDir
-			
-			raw_uun_path := learnDir+"/"+strings.ToLower(student_uun)+".pdf"
-			if _, err := os.Stat(raw_uun_path); err == nil {
-				// Such a file exists, so create a dummy Submission for it and then move the PDF into place
-				manual_sub := parselearn.Submission{}
-				manual_sub.UUN = student_uun
-				manual_sub.ExamNumber = student_examno
-				filemovestatus := moveFile(raw_uun_path, outputDir+"/"+student_examno+".pdf")
-				manual_sub.OutputFile = filemovestatus
-				submissions = append(submissions, manual_sub)
-			}
+	var filenameTemplate string
+    flag.StringVar(&filenameTemplate, "template", "{{.Course}}_{{.ExamNumber}}.pdf", "template for output file names, using the fields {{.Course}}, {{.ExamNumber}}, {{.UUN}}, {{.Late}} and {{.Sequence}}")
+	
+	var latePrefix string
+    flag.StringVar(&latePrefix, "lateprefix", "LATE-", "added to the start of the output file name for late submissions")
+	
+	var lateSuffix string
+    flag.StringVar(&lateSuffix, "latesuffix", "", "added to the end of the output file name (before .pdf) for late submissions")
+	
+	sequenceNumbers := flag.Bool("sequence", false, "start output file names with a zero-padded number, counting up in order of exam number? (true/false)")
+	
+	var numWorkers int
+    flag.IntVar(&numWorkers, "workers", runtime.NumCPU(), "number of Learn receipts to read in parallel")
+	
+	copyOnly := flag.Bool("copyonly", false, "copy files into outputdir and never delete anything from learndir? (true/false)")
+	
+	keepTemp := flag.Bool("keeptemp", false, "keep the temporary folder used when learndir is a zip file? (true/false)")
+	
+	acceptLate := flag.Bool("acceptlate", false, "use late submissions (with LATE- added to the file name) rather than skipping and deleting them? (true/false)")
+	
+	var maxMergeFiles int
+    flag.IntVar(&maxMergeFiles, "maxmergefiles", 10, "submissions with more files than this are reported as having too many files, rather than being merged (0 for no limit)")
+	
+	coverSheet := flag.Bool("coversheet", false, "start each output PDF with a cover sheet for the marker, giving the exam number, pages and whether it was late? (true/false)")
+	
+	mergeFiles := flag.Bool("mergepdfs", false, "merge submissions of several PDFs into one file, in the order they were uploaded? (true/false)")
+	
+	strictMode := flag.Bool("strict", false, "treat any student with no submission as a failure? (true/false)")
+	
+	resumeMode := flag.Bool("resume", false, "skip students who already have an on-time output file in outputdir? (true/false)")
+	
+	hardLink := flag.Bool("hardlink", false, "make output files hard links to the submitted files where possible, rather than full copies? (true/false)")
+	
+	noOverwrite := flag.Bool("nooverwrite", false, "never replace an existing output file, but leave any different submission in place and report the conflict instead? (true/false)")
+	
+	stripMetadata := flag.Bool("stripmetadata", false, "remove the Author, Title, Subject and Keywords (which may name the student) from output PDFs? (true/false)")
+	
+	var outputModeText string
+    flag.StringVar(&outputModeText, "outputmode", "0640", "permissions (in octal) for the output files and reports")
+	
+	preserveMtime := flag.Bool("preservemtime", false, "give output files the modification time of the submitted file, rather than the time they were copied? (true/false)")
+	
+	requireClean := flag.Bool("requireclean", false, "fail if any files are left in learndir (and the other folders) after processing, listing them? (true/false)")
+	
+	inPlace := flag.Bool("inplace", false, "rename each submission where it is, instead of moving it to outputdir (manifest.csv in the reports maps the old names to the new)? (true/false)")
+	
+	anonymiseReceipts := flag.Bool("anonymisereceipts", false, "with -keepreceipts, move the kept receipts to outputdir/receipts, named after the output file (so by exam number rather than UUN)? (true/false)")
+	
+	keepLate := flag.Bool("keeplate", false, "move late submissions that aren't used into outputdir/late (named by exam number and time), rather than deleting them? (true/false)")
+	
+	keepReceipts := flag.Bool("keepreceipts", false, "leave the Learn receipt files in learndir instead of deleting them? (true/false)")
+	
+	noClassList := flag.Bool("noclasslist", false, "ignore -classlist, and process everyone with a Learn receipt in the folders, using their UUN as the exam number? (true/false)")
+	
+	var simulateDeadlines string
+    flag.StringVar(&simulateDeadlines, "simulatedeadlines", "", "comma-separated deadlines (same form as deadline) to count the late students at, without moving or writing anything")
+	
+	reportsOnly := flag.Bool("reportsonly", false, "only write the reports, from the output files already in outputdir and the class list, without moving, copying or deleting anything? (true/false)")
+	
+	checkReceiptPath := flag.String("checkreceipt", "", "just read this one Learn receipt, printing the fields found in it and anything wrong with it")
+	
+	validateOnly := flag.Bool("validateonly", false, "only check the class list for problems, without processing any submissions? (true/false)")
+	
+	verboseMode := flag.Bool("v", false, "print details of every student's submissions")
+	quietMode := flag.Bool("q", false, "only print warnings and errors")
+	
+	debuggingMode := flag.Bool("debug", false, "print extra details for debugging? (true/false)")
+	
+	reportFormat := flag.String("reportformat", "csv", "form to write the reports in: csv, tsv (tab-separated) or json (an array of objects)")
+	
+	jsonReport := flag.Bool("jsonreport", false, "also write a summary of the run as a JSON file? (true/false)")
+	
+	flag.String("config", "", "YAML or JSON file of settings, named as the flags are (e.g. deadline: 2020-04-22-16-00); flags on the command line override it")
+	
+	// Settings from a config file are applied first, so that anything on the command line takes precedence
+	if config := configPath(os.Args[1:]); config != "" {
+		if err := loadConfig(config); err != nil {
+			fmt.Println("Could not read the config file: ", err)
+			os.Exit(exitSetupError)
 		}
-		
 	}
 	
-	*/
-	
-	fmt.Println("\n\nSuccessful submissions: ", len(submissions))
-	fmt.Println("\n\nBad submissions: ", len(bad_submissions))
-	fmt.Println("\n\nNo submissions: ", len(no_submissions))
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(exitClean)
+		}
+		os.Exit(exitSetupError)
+	}
 	
-	// TODO - remove timestamp from filename, and have it as a column in the csv. Make this just append details to csv file if it exists
-	report_time := time.Now().Format("2006-01-02-15-04-05")
-	parselearn.WriteSubmissionsToCSV(submissions, fmt.Sprintf("%s/%s-learn-success.csv", outputDir, report_time))
-	parselearn.WriteSubmissionsToCSV(bad_submissions, fmt.Sprintf("%s/%s-learn-errors.csv", outputDir, report_time))
-	parselearn.WriteSubmissionsToCSV(no_submissions, fmt.Sprintf("%s/%s-learn-nosubmission.csv", outputDir, report_time))
-
-	// Write submission summary to csv
-	file, err := os.OpenFile(fmt.Sprintf("%s/%s-learn-submissionsummary.csv", outputDir, report_time), os.O_RDWR|os.O_CREATE, os.ModePerm)
-	check(err)
-	defer file.Close()
-	err = gocsv.MarshalFile(&submission_summaries, file)
-	check(err)
+	if *verboseMode && *quietMode {
+		fmt.Println("Choose at most one of -v and -q")
+		os.Exit(exitSetupError)
+	}
+	logLevel := ingest.LevelNormal
+	if *verboseMode {
+		logLevel = ingest.LevelVerbose
+	}
+	if *quietMode {
+		logLevel = ingest.LevelQuiet
+	}
 	
-	// That's enough
-	os.Exit(0)
+	// The class list columns to read each student's details from
+	classlist_columns := ingest.ClassListColumns{UUN: colUUN, ExamNumber: colExamNo, ExtraTime: colExtraTime, Deadline: "Deadline", Status: "Status", Group: "Group"}
+	flag.Visit(func(f *flag.Flag) {
+		// Set on the command line or in the config file
+		if f.Name == "col-extratime" {
+			classlist_columns.ExtraTimeRequired = true
+		}
+	})
 	
-
-
-}
-
-// Move the path_from file to path_to, but only if there is not already a file at path_to
-func moveFile(path_from string, path_to string) string {
-
-	// Check path_from exists, and its age
-	file_from, err := os.Stat(path_from)
-	check(err)
-    time_from := file_from.ModTime()
-	
-	// If there is a file at path_to, check its age. If it is newer than the path_from file, then don't bother copying
-	file_to_exists := false
-    if file_to, err := os.Stat(path_to); err == nil {
-		file_to_exists = true
-		time_to := file_to.ModTime()
-		if(!time_from.Before(time_to)) {
-			// No need to copy over, but delete the path_from file since it is not needed
-			removeFile(path_from)
-			return "File already exists"
+	// Just look at one receipt, to find out why it can't be used
+	if *checkReceiptPath != "" {
+		problems := ingest.CheckReceipt(*checkReceiptPath)
+		if problems > 0 {
+			fmt.Println("receipt has", problems, "problems")
+			os.Exit(exitProblems)
 		}
-    }
+		os.Exit(exitClean)
+	}
 	
-	// Now copy the path_from file into the path_to location
-	err = CopyFile(path_from, path_to)
-	if err != nil {
-		fmt.Printf("CopyFile failed %q\n", err)
-	} else {
-		// Get rid of the path_from file, it's no longer needed
-		removeFile(path_from)
-		if(file_to_exists) {
-			return "File replaced"
-		} else {
-			return "File created"
+	// Just check the class list, without touching any submissions
+	if *validateOnly {
+		var classListPaths []string
+		for _, classListPath := range strings.Split(classListCSV, ",") {
+			if classListPath = strings.TrimSpace(classListPath); classListPath != "" {
+				classListPaths = append(classListPaths, classListPath)
+			}
+		}
+		problems := ingest.ValidateClassList(classListPaths, classlist_columns, logLevel)
+		if problems > 0 {
+			fmt.Println("class list has", problems, "problems")
+			os.Exit(exitProblems)
 		}
+		if !*quietMode {
+			fmt.Println("class list is OK")
+		}
+		os.Exit(exitClean)
 	}
 	
-	return "Done Nothing"
-}
-
-func removeFile(path string) {
-	err := os.Remove(path)
-	check(err)
-	return
-}
-
+	output_mode, err := strconv.ParseUint(outputModeText, 8, 32)
+	if err != nil || output_mode > 0777 {
+		fmt.Println("Bad -outputmode, expected permissions in octal such as 0640")
+		os.Exit(exitSetupError)
+	}
 	
-func check(e error) {
-    if e != nil {
-        panic(e)
-    }
-}
-
-
-
-// File copy functions - https://stackoverflow.com/a/21067803
-
-// CopyFile copies a file from src to dst. If src and dst files exist, and are
-// the same, then return success. Otherise, attempt to create a hard link
-// between the two files. If that fail, copy the file contents from src to dst.
-func CopyFile(src, dst string) (err error) {
-    sfi, err := os.Stat(src)
-    if err != nil {
-        return
-    }
-    if !sfi.Mode().IsRegular() {
-        // cannot copy non-regular files (e.g., directories,
-        // symlinks, devices, etc.)
-        return fmt.Errorf("CopyFile: non-regular source file %s (%q)", sfi.Name(), sfi.Mode().String())
-    }
-    dfi, err := os.Stat(dst)
-    if err != nil {
-        if !os.IsNotExist(err) {
-            return
-        }
-    } else {
-        if !(dfi.Mode().IsRegular()) {
-            return fmt.Errorf("CopyFile: non-regular destination file %s (%q)", dfi.Name(), dfi.Mode().String())
-        }
-        if os.SameFile(sfi, dfi) {
-            return
-        }
-    }
-    if err = os.Link(src, dst); err == nil {
-        return
-    }
-    err = copyFileContents(src, dst)
-    return
-}
-
-// copyFileContents copies the contents of the file named src to the file named
-// by dst. The file will be created if it does not already exist. If the
-// destination file exists, all it's contents will be replaced by the contents
-// of the source file.
-func copyFileContents(src, dst string) (err error) {
-    in, err := os.Open(src)
-    if err != nil {
-        return
-    }
-    defer in.Close()
-    out, err := os.Create(dst)
-    if err != nil {
-        return
-    }
-    defer func() {
-        cerr := out.Close()
-        if err == nil {
-            err = cerr
-        }
-    }()
-    if _, err = io.Copy(out, in); err != nil {
-        return
-    }
-    err = out.Sync()
-	
-	// Update the "last modified" time on the newly created file
-	currenttime := time.Now().Local()
-	err = os.Chtimes(dst, currenttime, currenttime)
+	result, err := ingest.Ingest(ingest.IngestOptions{
+		Course:            courseCode,
+		ClassListCSV:      classListCSV,
+		ClassListColumns:  classlist_columns,
+		NoClassList:       *noClassList,
+		LearnDir:          learnDir,
+		OtherDirs:         flag.Args(),
+		OutputDir:         outputDir,
+		Deadline:          deadline,
+		SimulateDeadlines: simulateDeadlines,
+		GroupDeadlines:    groupDeadlines,
+		Concessions:       concessions,
+		TimeZone:          timeZoneName,
+		GracePeriod:       gracePeriod,
+		HardCutoff:        hardCutoff,
+		Since:             since,
+		MinAge:            minAge,
+		MinBytes:          minBytes,
+		AuditLog:          auditLogPath,
+		ExamNoMap:         examNoMap,
+		HashSalt:          hashSalt,
+		Overrides:         overrides,
+		Only:              onlyUUNs,
+		Accommodations:    accommodations,
+		TurnitinDir:       turnitinDir,
+		TurnitinCSV:       turnitinCSV,
+		FormsDir:          formsDir,
+		FormsCSV:          formsCSV,
+		FilenameTemplate:  filenameTemplate,
+		SortBy:            sortBy,
+		LatePrefix:        latePrefix,
+		LateSuffix:        lateSuffix,
+		SequenceNumbers:   *sequenceNumbers,
+		Workers:           numWorkers,
+		CopyOnly:          *copyOnly,
+		KeepTemp:          *keepTemp,
+		AcceptLate:        *acceptLate,
+		MaxMergeFiles:     maxMergeFiles,
+		MergePDFs:         *mergeFiles,
+		CoverSheet:        *coverSheet,
+		Strict:            *strictMode,
+		Resume:            *resumeMode,
+		ReportsOnly:       *reportsOnly,
+		HardLink:          *hardLink,
+		NoOverwrite:       *noOverwrite,
+		PreserveMtime:     *preserveMtime,
+		OutputMode:        os.FileMode(output_mode),
+		StripMetadata:     *stripMetadata,
+		RequireClean:      *requireClean,
+		KeepReceipts:      *keepReceipts,
+		KeepLate:          *keepLate,
+		AnonymiseReceipts: *anonymiseReceipts,
+		InPlace:           *inPlace,
+		Debug:             *debuggingMode,
+		ReportFormat:      *reportFormat,
+		JSONReport:        *jsonReport,
+		LogLevel:          logLevel,
+	})
 	if err != nil {
 		fmt.Println(err)
+		// Anything that went wrong once files had started to be moved means the run stopped part way through
+		var setup_error *ingest.SetupError
+		if errors.As(err, &setup_error) {
+			os.Exit(exitSetupError)
+		}
+		os.Exit(exitFailed)
 	}
-    return
-}
-
-func PrettyPrintStruct(layout interface{}) error {
-
-	json, err := json.MarshalIndent(layout, "", "\t")
-	if err != nil {
-		return err
+	
+	// Finish with a line for scripts to read, whatever the logging level
+	fmt.Println(result.SummaryLine())
+	
+	// That's enough - the exit code says whether anything needs attention
+	if result.NeedsAttention {
+		os.Exit(exitProblems)
 	}
-
-	fmt.Println(string(json))
-	return nil
+	os.Exit(exitClean)
 }
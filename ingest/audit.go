@@ -0,0 +1,50 @@
+package ingest
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// The audit log, if one was asked for with -auditlog, records every operation on
+// the students' files so there is a permanent record of what was moved or deleted
+type auditLog struct {
+	file   *os.File
+	writer *csv.Writer
+	mutex  sync.Mutex
+}
+
+// Open the audit log, adding to the end of it if it already exists
+func openAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{file: f, writer: csv.NewWriter(f)}, nil
+}
+
+// Record a file operation in the audit log, if there is one
+func (a *auditLog) record(operation string, source string, destination string, result string) {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.writer.Write([]string{time.Now().Format(time.RFC3339), operation, source, destination, result})
+	// Flush straight away, so the record survives even if the program crashes
+	a.writer.Flush()
+	if err := a.writer.Error(); err != nil {
+		fmt.Println("Could not write to the audit log: ", err)
+	}
+}
+
+// Close the audit log at the end of the run
+func (a *auditLog) close() {
+	if a == nil {
+		return
+	}
+	a.writer.Flush()
+	a.file.Close()
+}
@@ -0,0 +1,79 @@
+package ingest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The SHA-256 of a file's contents, as a hex string
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Whether two files have the same contents; if either can't be read, they are taken to differ
+func sameContents(a, b string) bool {
+	checksum_a, err := fileChecksum(a)
+	if err != nil {
+		return false
+	}
+	checksum_b, err := fileChecksum(b)
+	return err == nil && checksum_a == checksum_b
+}
+
+// Read the checksums recorded for each student's output file by earlier runs, from the success
+// reports in reportsDir; where a student appears in several reports, the latest one is used
+func (r *run) readPreviousChecksums(reportsDir string) map[string]string {
+	checksums := map[string]string{}
+	var reports []string
+	for _, format := range reportFormats {
+		matches, _ := filepath.Glob(filepath.Join(reportsDir, "*", "learn-success."+format))
+		reports = append(reports, matches...)
+	}
+	sort.Strings(reports) // the report folders are named by time, so this puts the latest last
+	for _, report := range reports {
+		rows, err := readReport(report)
+		if err != nil {
+			r.logPrintln(LevelVerbose, "could not read checksums from", report, ":", err)
+			continue
+		}
+		for _, row := range rows {
+			if row["Checksum"] != "" {
+				checksums[normaliseUUN(row["UUN"])] = strings.TrimSpace(row["Checksum"])
+			}
+		}
+	}
+	return checksums
+}
+
+// Find the students (keyed by UUN) whose files have the same checksum as someone else's,
+// giving the UUNs in order for each checksum shared by two or more of them
+func duplicateChecksums(checksums map[string]string) map[string][]string {
+	by_checksum := map[string][]string{}
+	for uun, sum := range checksums {
+		if sum != "" {
+			by_checksum[sum] = append(by_checksum[sum], uun)
+		}
+	}
+	duplicates := map[string][]string{}
+	for sum, uuns := range by_checksum {
+		if len(uuns) > 1 {
+			sort.Strings(uuns)
+			duplicates[sum] = uuns
+		}
+	}
+	return duplicates
+}
@@ -0,0 +1,74 @@
+package ingest
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Read the students from a class list csv, finding each field by the name of its column
+// in the header row, so that class lists from different sources can be used as they are
+func readClassListCSV(r io.Reader, columns ClassListColumns) ([]Students, error) {
+	reader := csv.NewReader(skipBOM(r))
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	find := func(name string, required bool) (int, error) {
+		if i, ok := index[strings.ToLower(strings.TrimSpace(name))]; ok && name != "" {
+			return i, nil
+		}
+		if required {
+			return -1, fmt.Errorf("no %q column in the class list", name)
+		}
+		return -1, nil
+	}
+	uun_col, err := find(columns.UUN, true)
+	if err != nil {
+		return nil, err
+	}
+	examno_col, err := find(columns.ExamNumber, !columns.ExamNumberOptional)
+	if err != nil {
+		return nil, err
+	}
+	extratime_col, err := find(columns.ExtraTime, columns.ExtraTimeRequired)
+	if err != nil {
+		return nil, err
+	}
+	deadline_col, _ := find(columns.Deadline, false)
+	status_col, _ := find(columns.Status, false)
+	group_col, _ := find(columns.Group, false)
+
+	var students []Students
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(i int) string {
+			if i < 0 || i >= len(record) {
+				return ""
+			}
+			return record[i]
+		}
+		students = append(students, Students{
+			StudentID:     field(uun_col),
+			ExamNumber:    field(examno_col),
+			ExtraTimeText: field(extratime_col),
+			DeadlineText:  field(deadline_col),
+			Status:        field(status_col),
+			Group:         field(group_col),
+		})
+	}
+	return students, nil
+}
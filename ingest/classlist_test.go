@@ -0,0 +1,75 @@
+package ingest
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSkipBOM(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"with a byte order mark", "\xef\xbb\xbfUUN,Exam Number", "UUN,Exam Number"},
+		{"without one", "UUN,Exam Number", "UUN,Exam Number"},
+		{"only in the middle", "UUN,\xef\xbb\xbfExam Number", "UUN,\xef\xbb\xbfExam Number"},
+		{"shorter than a byte order mark", "U", "U"},
+		{"empty", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := io.ReadAll(skipBOM(strings.NewReader(test.input)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// A class list saved from Excel starts with a byte order mark, which must not stop the UUN column being found
+func TestReadClassListCSVWithBOM(t *testing.T) {
+	f, err := os.Open("testdata/classlist-bom.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	students, err := readClassListCSV(f, ClassListColumns{UUN: "UUN", ExamNumber: "Exam Number", ExtraTime: "Extra Time"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(students) != 2 {
+		t.Fatalf("got %d students, want 2", len(students))
+	}
+	if students[0].StudentID != "s1234567" || students[0].ExamNumber != "B123456" {
+		t.Errorf("first student: got %s %s, want s1234567 B123456", students[0].StudentID, students[0].ExamNumber)
+	}
+	if students[1].StudentID != "s7654321" || students[1].ExtraTimeText != "15" {
+		t.Errorf("second student: got %s with extra time %q, want s7654321 with 15", students[1].StudentID, students[1].ExtraTimeText)
+	}
+}
+
+// Without an extra time column everyone has none, unless the column was asked for by name
+func TestReadClassListCSVExtraTimeColumn(t *testing.T) {
+	classlist := "UUN,Exam Number,Extra time minutes\ns1234567,B123456,15\n"
+	columns := ClassListColumns{UUN: "UUN", ExamNumber: "Exam Number", ExtraTime: "Extra Time"}
+
+	students, err := readClassListCSV(strings.NewReader(classlist), columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(students) != 1 || students[0].ExtraTimeText != "" {
+		t.Errorf("got %+v, want one student with no extra time", students)
+	}
+
+	columns.ExtraTimeRequired = true
+	if _, err := readClassListCSV(strings.NewReader(classlist), columns); err == nil {
+		t.Error("a missing extra time column that was asked for by name should be an error")
+	}
+}
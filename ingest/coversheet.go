@@ -0,0 +1,98 @@
+package ingest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Write a one-page A4 PDF at path with permissions mode, with the title in large type followed by each of the lines
+func writeCoverSheet(path string, title string, lines []string, mode os.FileMode) error {
+	var content bytes.Buffer
+	content.WriteString("BT\n/F1 28 Tf\n72 740 Td\n")
+	fmt.Fprintf(&content, "(%s) Tj\n", pdfString(title))
+	content.WriteString("/F1 14 Tf\n0 -48 Td\n")
+	for _, line := range lines {
+		fmt.Fprintf(&content, "(%s) Tj\n0 -24 Td\n", pdfString(line))
+	}
+	content.WriteString("ET\n")
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+	}
+
+	// Each object's position in the file goes in the cross-reference table at the end
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = pdf.Len()
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return os.WriteFile(path, pdf.Bytes(), mode)
+}
+
+// Escape text for use in a PDF string, leaving out anything that isn't printable ASCII
+func pdfString(text string) string {
+	var escaped strings.Builder
+	for _, c := range text {
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			escaped.WriteRune('\\')
+			escaped.WriteRune(c)
+		case c >= 32 && c < 127:
+			escaped.WriteRune(c)
+		default:
+			escaped.WriteRune('?')
+		}
+	}
+	return escaped.String()
+}
+
+// Put a cover sheet with the title and lines in front of the first page of the PDF at path,
+// which is left with permissions mode
+func prependCoverSheet(path string, title string, lines []string, mode os.FileMode) (err error) {
+	defer func() {
+		// The PDF library can panic on badly broken files
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	cover, err := os.CreateTemp(filepath.Dir(path), ".cover-*.pdf")
+	if err != nil {
+		return err
+	}
+	cover.Close()
+	defer os.Remove(cover.Name())
+	if err = writeCoverSheet(cover.Name(), title, lines, mode); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	if err = mergePDFs([]string{cover.Name(), path}, tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	os.Chmod(tmp.Name(), mode)
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,2298 @@
+// Package ingest moves students' submissions downloaded from Learn into a folder of files named by
+// exam number, ready to be marked anonymously, and writes reports on what was done with each student
+package ingest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"encoding/json"
+	"text/template"
+
+	"github.com/gocarina/gocsv"
+	"github.com/georgekinnear/parselearn"
+)
+
+// Structure for the class list csv
+type Students struct {
+	StudentID       string  `csv:"UUN"`
+	ExamNumber      string  `csv:"Exam Number"`
+	ExtraTimeText   string  `csv:"Extra Time"`
+	ExtraTime      	int     `csv:"-"`
+	DeadlineText    string  `csv:"Deadline"`
+	DeadlineTime    time.Time `csv:"-"`
+	Status          string  `csv:"Status"`
+	Group           string  `csv:"Group"`
+	GroupDeadlineTime time.Time `csv:"-"`
+}
+
+// Whether the student has left the course, going by the optional Status column of the class list
+func (s Students) withdrawn() bool {
+	status := strings.ToLower(strings.TrimSpace(s.Status))
+	return strings.HasPrefix(status, "withdr") || status == "left"
+}
+
+// The deadline that applies to this student, and the minutes of extra time they have after it
+func (s Students) deadlineAndExtraTime(deadline_time time.Time) (time.Time, int) {
+	if !s.DeadlineTime.IsZero() {
+		// Students with their own deadline in the class list are not given extra time on top of it
+		return s.DeadlineTime, 0
+	}
+	if !s.GroupDeadlineTime.IsZero() {
+		// Students in a group with its own deadline (e.g. an afternoon sitting) still have their extra time
+		return s.GroupDeadlineTime, s.ExtraTime
+	}
+	return deadline_time, s.ExtraTime
+}
+
+// The time after which this student's submissions are late
+func (s Students) deadline(deadline_time time.Time) time.Time {
+	student_deadline, extratime := s.deadlineAndExtraTime(deadline_time)
+	return student_deadline.Add(time.Minute * time.Duration(extratime))
+}
+
+// Decide whether a submission is late, given the deadline and the student's minutes of extra time
+func isLate(submittedAt time.Time, deadline time.Time, extraTimeMinutes int) bool {
+	if !submittedAt.After(deadline) {
+		return false
+	}
+	if extraTimeMinutes > 0 {
+		// For students with extra time noted in the class list, their submission deadline is shifted
+		return submittedAt.After(deadline.Add(time.Minute * time.Duration(extraTimeMinutes)))
+	}
+	// For students with no allowance of extra time, their submission is late
+	return true
+}
+
+/*
+type SubmissionSummary struct {
+	UUN			       string  `csv:"UUN"`
+	ExamNumber         string  `csv:"ExamNumber"`
+	DateSubmitted      string  `csv:"DateSubmitted"`
+	LateSubmission     string  `csv:"LateSubmission"`
+	ExtraTime	       int     `csv:"ExtraTime"`
+	Filename           string  `csv:"Filename"`
+	NumberOfFiles      int     `csv:"NumberOfFiles"`
+}
+*/
+
+// Process the submissions for one exam: pick the one to mark for each student in the class list,
+// move it into the output folder under its anonymised name, and write the reports. Problems with
+// submissions are in the result, not returned as errors. An error that stops the run before any
+// student's files are dealt with (such as a bad setting or class list) is a *SetupError; any other
+// error, such as the reports not being written, means the run stopped part way through.
+// Files in opts.LearnDir that are used are moved out of it (or copied, with CopyOnly), and their
+// receipts removed unless KeepReceipts is set. Submissions that are not used because they were
+// superseded, are late (without AcceptLate) or are beyond HardCutoff are DELETED along with their
+// receipts, unless KeepLate is set, which moves the late and cut-off ones to OutputDir/late instead;
+// CopyOnly deletes nothing. Files that need sorting out by hand (bad submissions, unknown students,
+// UUN mismatches and conflicts) are left where they are. Every path read or written comes from
+// opts, so a run can be driven from a temporary folder of receipts, PDFs and a class list csv.
+func Ingest(opts IngestOptions) (IngestResult, error) {
+	r := &run{
+		logger:        logger{level: opts.LogLevel},
+		timeZone:      time.Local,
+		copyOnly:      opts.CopyOnly,
+		hardLink:      opts.HardLink,
+		noOverwrite:   opts.NoOverwrite,
+		stripMetadata: opts.StripMetadata,
+		outputMode:    0640,
+		preserveMtime: opts.PreserveMtime,
+		reportFormat:  "csv",
+	}
+	result, err := r.ingest(opts)
+	if err != nil && !r.started {
+		err = &SetupError{Err: err}
+	}
+	return result, err
+}
+
+// Do the work of Ingest, with the settings in r
+func (r *run) ingest(opts IngestOptions) (IngestResult, error) {
+	
+	courseCode := opts.Course
+	classListCSV := opts.ClassListCSV
+	classlist_columns := opts.ClassListColumns
+	learnDir := opts.LearnDir
+	outputDir := opts.OutputDir
+	deadline := opts.Deadline
+	timeZoneName := opts.TimeZone
+	gracePeriod := opts.GracePeriod
+	since := opts.Since
+	minBytes := opts.MinBytes
+	auditLogPath := opts.AuditLog
+	examNoMap := opts.ExamNoMap
+	onlyUUNs := opts.Only
+	formsDir := opts.FormsDir
+	formsCSV := opts.FormsCSV
+	filenameTemplate := opts.FilenameTemplate
+	latePrefix := opts.LatePrefix
+	lateSuffix := opts.LateSuffix
+	numWorkers := opts.Workers
+	maxMergeFiles := opts.MaxMergeFiles
+	if opts.OutputMode != 0 {
+		r.outputMode = opts.OutputMode
+	}
+	if opts.ReportFormat != "" {
+		if !validReportFormat(opts.ReportFormat) {
+			return IngestResult{}, fmt.Errorf("Bad -reportformat %q, expected one of %s", opts.ReportFormat, strings.Join(reportFormats, ", "))
+		}
+		r.reportFormat = opts.ReportFormat
+	}
+	if opts.SortBy != "" && opts.SortBy != "uun" && opts.SortBy != "examno" {
+		return IngestResult{}, fmt.Errorf("Bad -sortby %q, expected uun or examno", opts.SortBy)
+	}
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+
+	// Deadlines and submission times are all read as times in the exam's time zone, so that they
+	// compare correctly whether or not daylight saving time is in force
+	if timeZoneName != "" {
+		zone, err := time.LoadLocation(timeZoneName)
+		if err != nil {
+			return IngestResult{}, fmt.Errorf("Bad -timezone: %v", err)
+		}
+		r.timeZone = zone
+	}
+	
+	// Without a deadline (e.g. for practice uploads), no submission is ever late
+	no_deadline := strings.TrimSpace(deadline) == "" || strings.EqualFold(strings.TrimSpace(deadline), "none")
+	var deadline_time time.Time
+	var e error
+	if !no_deadline {
+		deadline_time, e = parseDeadline(deadline, r.timeZone)
+		if e != nil {
+			return IngestResult{}, fmt.Errorf("Bad deadline: %v", e)
+		}
+	} else if opts.HardCutoff > 0 {
+		r.logPrintln(LevelQuiet, "WARNING: -hardcutoff has no effect without a deadline")
+	}
+	
+	// Only receipts modified after this time are read, when -since is given
+	var since_time time.Time
+	if since != "" {
+		since_time, e = parseDeadline(since, r.timeZone)
+		if e != nil {
+			return IngestResult{}, fmt.Errorf("Bad -since time: %v", e)
+		}
+	}
+	
+	// Add the grace period to the deadline - with the default of 59 seconds, a deadline of 12:00 means submissions up to 12:00:59 are on time but 12:01:00 is late
+	deadline_time = deadline_time.Add(gracePeriod)
+	
+	// Candidate deadlines to try out with -simulatedeadlines, which only reports and leaves every file alone
+	var simulated_deadlines []time.Time
+	for _, candidate := range strings.Split(opts.SimulateDeadlines, ",") {
+		if candidate = strings.TrimSpace(candidate); candidate == "" {
+			continue
+		}
+		candidate_time, err := parseDeadline(candidate, r.timeZone)
+		if err != nil {
+			return IngestResult{}, fmt.Errorf("Bad -simulatedeadlines: %v", err)
+		}
+		simulated_deadlines = append(simulated_deadlines, candidate_time.Add(gracePeriod))
+	}
+	simulating := len(simulated_deadlines) > 0
+	
+	// Check the output filename template can be used
+	if opts.SequenceNumbers {
+		filenameTemplate = "{{.Sequence}}_"+filenameTemplate
+	}
+	output_template, err := template.New("output").Parse(filenameTemplate)
+	if err == nil {
+		err = output_template.Execute(io.Discard, OutputName{})
+	}
+	if err != nil {
+		return IngestResult{}, fmt.Errorf("Bad output filename template: %v", err)
+	}
+	if err := checkSafeFilename(lateFilename("x.pdf", latePrefix, lateSuffix)); err != nil {
+		return IngestResult{}, fmt.Errorf("Bad -lateprefix or -latesuffix: %v", err)
+	}
+	
+	r.logPrintln(LevelNormal, "course: ", courseCode)
+	if no_deadline {
+		r.logPrintln(LevelNormal, "deadline: none (lateness is not evaluated)")
+	} else {
+		r.logPrintln(LevelNormal, "deadline: ", deadline_time.Format("2006-01-02 at 15:04:05"))	
+	}
+	r.logPrintln(LevelNormal, "learn folder: ", learnDir)
+	r.logPrintln(LevelNormal, "other folders to read: ", opts.OtherDirs)
+	
+	// With -reportsonly, the output files must already be in place, since nothing is made or moved
+	if opts.ReportsOnly {
+		if opts.InPlace {
+			return IngestResult{}, fmt.Errorf("-reportsonly can't be used with -inplace, as the output files are not in outputdir")
+		}
+		if info, err := os.Stat(outputDir); err != nil || !info.IsDir() {
+			return IngestResult{}, fmt.Errorf("-reportsonly needs the output folder %s to exist already", outputDir)
+		}
+	}
+	
+	// Check the output directory exists, and if not then make it
+	if !simulating && !opts.ReportsOnly {
+		err = ensureDir(outputDir)
+		if err != nil {
+			os.MkdirAll(outputDir, os.ModePerm)
+		}
+		err = ensureDir(outputDir)
+		if err != nil {
+			return IngestResult{}, err
+		}
+	}
+	
+	// Start the audit log of file operations
+	if auditLogPath != "" && !simulating && !opts.ReportsOnly {
+		r.audit, err = openAuditLog(auditLogPath)
+		if err != nil {
+			return IngestResult{}, fmt.Errorf("Could not open the audit log: %v", err)
+		}
+		defer r.audit.close()
+	}
+	
+	// If given the zip file downloaded from Learn (or a tar.gz of it), unzip it into a temporary folder and read from there
+	if opts.InPlace && isArchive(learnDir) {
+		return IngestResult{}, fmt.Errorf("-inplace can't be used when learndir is a zip or tar.gz file, as the unzipped files are deleted at the end")
+	}
+	tempDir := ""
+	if isArchive(learnDir) && !opts.ReportsOnly {
+		tempDir, err = os.MkdirTemp("", "gradex-ingest-")
+		if err != nil {
+			return IngestResult{}, err
+		}
+		r.logPrintln(LevelNormal, "unzipping", learnDir, "to", tempDir)
+		err = extractArchive(learnDir, tempDir)
+		if err != nil {
+			os.RemoveAll(tempDir)
+			return IngestResult{}, err
+		}
+		learnDir = tempDir
+		
+		// Tidy up the unzipped Learn download at the end, unless asked to keep it for looking at bad submissions
+		defer func() {
+			if opts.KeepTemp {
+				r.logPrintln(LevelNormal, "unzipped Learn files kept in", tempDir)
+			} else {
+				os.RemoveAll(tempDir)
+			}
+		}()
+	}
+	
+	// Check that the input folder exists
+	if !opts.ReportsOnly {
+		err = ensureDir(learnDir)
+		if err != nil {
+			return IngestResult{}, err
+		}
+	}
+	
+	// Any other folders of Learn files are read as well as learnDir (these can be glob patterns, like exports/*)
+	input_dirs := []string{learnDir}
+	for _, pattern := range opts.OtherDirs {
+		other_dirs, err := filepath.Glob(pattern)
+		if err != nil || len(other_dirs) == 0 {
+			other_dirs = []string{pattern}
+		}
+		for _, other_dir := range other_dirs {
+			if info, err := os.Stat(other_dir); err != nil || !info.IsDir() {
+				return IngestResult{}, fmt.Errorf("Not a folder: %s", other_dir)
+			}
+			input_dirs = append(input_dirs, other_dir)
+		}
+	}
+	// With -reportsonly, only outputdir is looked at
+	if opts.ReportsOnly {
+		input_dirs = nil
+	}
+	
+	// regex to read the UUN that appears in the Learn files, in either case since it is normalised afterwards
+	finduun, _ := regexp.Compile("(?i)_(s[0-9]{7})_attempt_")
+	
+	// Read the exam numbers kept separately from the class list, if there are any
+	var examno_map = map[string]string{}
+	if examNoMap != "" {
+		r.logPrintln(LevelNormal, "exam number csv: ", examNoMap)
+		mapFile, err := os.Open(examNoMap)
+		if err != nil {
+			return IngestResult{}, err
+		}
+		map_raw, err := readClassListCSV(mapFile, ClassListColumns{UUN: classlist_columns.UUN, ExamNumber: classlist_columns.ExamNumber})
+		mapFile.Close()
+		if err != nil {
+			return IngestResult{}, fmt.Errorf("%s: %v", examNoMap, err)
+		}
+		for _, s := range map_raw {
+			if examno := strings.TrimSpace(s.ExamNumber); examno != "" {
+				examno_map[normaliseUUN(s.StudentID)] = examno
+			}
+		}
+		// The class list itself need not have exam numbers
+		classlist_columns.ExamNumberOptional = true
+	}
+	if opts.HashSalt != "" {
+		classlist_columns.ExamNumberOptional = true
+	}
+	
+	// Read the deadlines of groups of students who sit the exam at a different time, e.g. in the afternoon
+	var group_deadlines = map[string]time.Time{}
+	if opts.GroupDeadlines != "" {
+		r.logPrintln(LevelNormal, "group deadlines csv: ", opts.GroupDeadlines)
+		groupsFile, err := os.Open(opts.GroupDeadlines)
+		if err != nil {
+			return IngestResult{}, err
+		}
+		groups_raw := []GroupDeadline{}
+		err = gocsv.Unmarshal(skipBOM(groupsFile), &groups_raw)
+		groupsFile.Close()
+		if err != nil {
+			return IngestResult{}, fmt.Errorf("%s: %v", opts.GroupDeadlines, err)
+		}
+		for _, group := range groups_raw {
+			group_deadline, err := parseDeadline(group.Deadline, r.timeZone)
+			if err != nil {
+				return IngestResult{}, fmt.Errorf("%s: deadline for group %s %v", opts.GroupDeadlines, group.Group, err)
+			}
+			group_deadlines[strings.ToLower(strings.TrimSpace(group.Group))] = group_deadline.Add(gracePeriod)
+		}
+		r.logPrintln(LevelNormal, "group deadlines: ", len(group_deadlines))
+	}
+	
+	// Read the extra time granted since the class list was frozen, which replaces what the class list gives
+	var concessions = map[string]Concession{}
+	if opts.Concessions != "" {
+		r.logPrintln(LevelNormal, "concessions csv: ", opts.Concessions)
+		concessionsFile, err := os.Open(opts.Concessions)
+		if err != nil {
+			return IngestResult{}, err
+		}
+		concessions_raw := []Concession{}
+		err = gocsv.Unmarshal(skipBOM(concessionsFile), &concessions_raw)
+		concessionsFile.Close()
+		if err != nil {
+			return IngestResult{}, fmt.Errorf("%s: %v", opts.Concessions, err)
+		}
+		for _, concession := range concessions_raw {
+			uun := normaliseUUN(concession.StudentID)
+			concession.ExtraTime, err = parseExtraTime(concession.ExtraTimeText)
+			if err != nil {
+				return IngestResult{}, fmt.Errorf("%s: extra time for %s %v", opts.Concessions, uun, err)
+			}
+			concessions[uun] = concession
+		}
+		r.logPrintln(LevelNormal, "concessions: ", len(concessions))
+	}
+	var classlist_extratime = map[string]int{} // the extra time in the class list, for students with a concession
+	
+	// Parse the class list - there may be several csv files, separated by commas, which are merged together
+	classlist := map[string]Students{}
+	var missing_examno []Students
+	var withdrawn []Students
+	var bad_classlist []string
+	var enrolled = map[string]bool{} // everyone in the class lists, including those left out of this run
+	classListPaths := strings.Split(classListCSV, ",")
+	if opts.NoClassList {
+		classListPaths = nil
+	}
+	for _, classListPath := range classListPaths {
+		classListPath = strings.TrimSpace(classListPath)
+		if classListPath == "" {
+			continue
+		}
+		r.logPrintln(LevelNormal, "class list csv: ", classListPath)
+		classListFile, err := os.Open(classListPath)
+		if err != nil {
+			return IngestResult{}, err
+		}
+
+		classlist_raw, err := readClassListCSV(classListFile, classlist_columns)
+		classListFile.Close()
+		if err != nil {
+			return IngestResult{}, fmt.Errorf("%s: %v", classListPath, err)
+		}
+		
+		// Make this into a map with UUNs as keys
+		for _, s := range classlist_raw {
+			// Key the map by the normalised UUN, so that it matches the UUNs taken from the Learn file names
+			s.StudentID = normaliseUUN(s.StudentID)
+			enrolled[s.StudentID] = true
+			// Students who have withdrawn are not expected to submit, so leave them out and list them separately
+			if s.withdrawn() {
+				r.logPrintln(LevelVerbose, s.StudentID, "has withdrawn")
+				withdrawn = append(withdrawn, s)
+				continue
+			}
+			// Read the extra time, making sure it is a sensible number of minutes
+			extratime, err := parseExtraTime(s.ExtraTimeText)
+			if err != nil {
+				bad_classlist = append(bad_classlist, fmt.Sprintf("%s in %s: extra time %v", s.StudentID, classListPath, err))
+				continue
+			}
+			s.ExtraTime = extratime
+			if concession, ok := concessions[s.StudentID]; ok {
+				r.logPrintf(LevelVerbose, "%s has a concession of %d minutes extra time (class list: %d) %s\n", s.StudentID, concession.ExtraTime, extratime, concession.Note)
+				classlist_extratime[s.StudentID] = extratime
+				s.ExtraTime = concession.ExtraTime
+			}
+			// Read the student's own deadline, if they have one, which replaces the normal deadline
+			if strings.TrimSpace(s.DeadlineText) != "" {
+				s.DeadlineTime, err = parseDeadline(s.DeadlineText, r.timeZone)
+				if err != nil {
+					bad_classlist = append(bad_classlist, fmt.Sprintf("%s in %s: deadline %v", s.StudentID, classListPath, err))
+					continue
+				}
+				s.DeadlineTime = s.DeadlineTime.Add(gracePeriod)
+			}
+			// Students in a group with its own deadline are judged against that, rather than the normal deadline
+			s.GroupDeadlineTime = group_deadlines[strings.ToLower(strings.TrimSpace(s.Group))]
+			// Students without an exam number can't be given an output file, so leave them out and report them
+			s.ExamNumber = strings.TrimSpace(s.ExamNumber)
+			if s.ExamNumber == "" {
+				s.ExamNumber = examno_map[s.StudentID]
+			}
+			if s.ExamNumber == "" && opts.HashSalt != "" {
+				s.ExamNumber = hashedExamNumber(opts.HashSalt, s.StudentID)
+				r.logPrintln(LevelVerbose, s.StudentID, "has no exam number - using", s.ExamNumber, "from the salted hash")
+			}
+			if s.ExamNumber == "" {
+				r.logPrintln(LevelQuiet, "WARNING:", s.StudentID, "has no exam number in", classListPath)
+				missing_examno = append(missing_examno, s)
+				continue
+			}
+			// If the student already appeared in an earlier class list, keep that first entry
+			if existing, ok := classlist[s.StudentID]; ok {
+				if existing.ExamNumber != s.ExamNumber {
+					r.logPrintf(LevelQuiet, "WARNING: %s has exam number %s in %s but %s in an earlier class list - keeping %s\n", s.StudentID, s.ExamNumber, classListPath, existing.ExamNumber, existing.ExamNumber)
+				}
+				continue
+			}
+			classlist[s.StudentID] = s
+		}
+	}
+	
+	// Two students with the same exam number, or ones that differ only by leading zeros (e.g. because a spreadsheet
+	// took them off one copy of the class list), could end up sharing an output file, so treat that as a problem
+	var examno_owner = map[string]string{}
+	var digits_owner = map[string]string{}
+	var classlist_uuns []string
+	for uun := range classlist {
+		classlist_uuns = append(classlist_uuns, uun)
+	}
+	sort.Strings(classlist_uuns)
+	for _, uun := range classlist_uuns {
+		digits := examNumberDigits(classlist[uun].ExamNumber)
+		if other, ok := examno_owner[classlist[uun].ExamNumber]; ok {
+			bad_classlist = append(bad_classlist, fmt.Sprintf("%s and %s have the same exam number %s", other, uun, classlist[uun].ExamNumber))
+			continue
+		}
+		if other, ok := digits_owner[digits]; ok {
+			bad_classlist = append(bad_classlist, fmt.Sprintf("%s and %s have exam numbers %s and %s, which are the same apart from leading zeros", other, uun, classlist[other].ExamNumber, classlist[uun].ExamNumber))
+			continue
+		}
+		examno_owner[classlist[uun].ExamNumber] = uun
+		digits_owner[digits] = uun
+	}
+	
+	// Without a class list, everyone with a receipt in the folders is processed, under their UUN
+	if opts.NoClassList {
+		for uun := range receiptUUNs(input_dirs, finduun) {
+			enrolled[uun] = true
+			examno := uun
+			if opts.HashSalt != "" {
+				examno = hashedExamNumber(opts.HashSalt, uun)
+			}
+			classlist[uun] = Students{StudentID: uun, ExamNumber: examno}
+		}
+		r.logPrintln(LevelNormal, "no class list - using the", len(classlist), "students with receipts in the folders")
+	}
+	
+	// Don't go any further if the extra time or deadline is wrong for anyone, since it would affect which submissions are late,
+	// or if exam numbers are shared, since it would affect which file is whose
+	if len(bad_classlist) > 0 {
+		r.logPrintln(LevelQuiet, "Invalid extra time, deadline or exam number in the class list:")
+		for _, problem := range bad_classlist {
+			r.logPrintln(LevelQuiet, " - ", problem)
+		}
+		return IngestResult{}, fmt.Errorf("%d problems in the class list", len(bad_classlist))
+	}
+	
+	r.logPrintln(LevelNormal, "class list contains ", len(classlist), "students")
+	if opts.Debug {
+		PrettyPrintStruct(classlist)
+	}
+	
+	
+	// Number the students in order of exam number, for use in output file names
+	var sequence_numbers = map[string]string{}
+	var examnos []string
+	for _, s := range classlist {
+		examnos = append(examnos, s.ExamNumber)
+	}
+	sort.Strings(examnos)
+	sequence_width := len(strconv.Itoa(len(examnos)))
+	for i, examno := range examnos {
+		sequence_numbers[examno] = fmt.Sprintf("%0*d", sequence_width, i+1)
+	}
+	
+	// Students known to have an accommodation, for checking their extra time has been put in the class list
+	var accommodations = map[string]bool{}
+	if opts.Accommodations != "" {
+		accommodations, err = parseUUNList(opts.Accommodations)
+		if err != nil {
+			return IngestResult{}, fmt.Errorf("Could not read the -accommodations list: %v", err)
+		}
+	}
+	var missing_extra_time []string // students on the accommodations list with no extra time, who were marked LATE
+	
+	// Leave out everyone not on the -only list, so that their submissions and output files are not touched
+	if onlyUUNs != "" {
+		only, err := parseUUNList(onlyUUNs)
+		if err != nil {
+			return IngestResult{}, fmt.Errorf("Could not read the -only list: %v", err)
+		}
+		for uun := range only {
+			if _, ok := classlist[uun]; !ok {
+				r.logPrintln(LevelQuiet, "WARNING:", uun, "is in the -only list but not the class list")
+			}
+		}
+		for uun := range classlist {
+			if !only[uun] {
+				delete(classlist, uun)
+			}
+		}
+		r.logPrintln(LevelNormal, "only processing", len(classlist), "students")
+	}
+
+
+	// Find all the Learn receipt files, in learnDir and any other folders given
+	var receipt_files []string
+	var receipt_dirs = map[string]string{} // the folder each receipt was found in, which may be a subfolder of the input folder
+	var unchanged_uuns = map[string]bool{} // students with receipts from before -since, which are left as they are
+	var recent_uuns = map[string]bool{} // students with files newer than -minage, which may still be being written
+	var inplace_outputs = map[string]string{} // with -inplace, the folder each file other than a receipt is in, to find earlier output files
+	too_recent := func(f os.FileInfo) bool {
+		return opts.MinAge > 0 && time.Since(f.ModTime()) < opts.MinAge
+	}
+	for _, input_dir := range input_dirs {
+		filepath.Walk(input_dir, func(path string, f os.FileInfo, _ error) error {
+			if !f.IsDir() {
+				if _, ok := inplace_outputs[f.Name()]; opts.InPlace && !ok {
+					inplace_outputs[f.Name()] = filepath.Dir(path)
+				}
+				if strings.HasSuffix(strings.ToLower(f.Name()), ".txt") {
+					match := finduun.FindStringSubmatch(f.Name())
+					if match == nil {
+						// Not a Learn receipt, e.g. a kept receipt from an earlier run or someone's notes
+						r.logPrintln(LevelVerbose, "Ignoring", path, "as it is not named like a Learn receipt")
+						return nil
+					}
+					if !since_time.IsZero() && f.ModTime().Before(since_time) {
+						unchanged_uuns[normaliseUUN(match[1])] = true
+						return nil
+					}
+					if too_recent(f) {
+						// Learn may still be writing it, so leave the student until a later run
+						recent_uuns[normaliseUUN(match[1])] = true
+						return nil
+					}
+					if _, ok := receipt_dirs[f.Name()]; ok {
+						// The same receipt is in more than one folder, so only read the first
+						return nil
+					}
+					receipt_files = append(receipt_files, f.Name())
+					// Newer Learn exports put each attempt in its own subfolder, so keep the folder the
+					// receipt is actually in, since the submitted files are alongside it
+					receipt_dirs[f.Name()] = filepath.Dir(path)
+				}
+				}
+			return nil
+		})
+	}
+
+	// Decide if a submission is LATE or not - if the time can't be read, it can't be
+	// known to be on time, so it is marked for checking by hand
+	mark_late := func(submission *parselearn.Submission, uun string) {
+		sub_time, err := parseSubmissionTime(submission.DateSubmitted, r.timeZone)
+		if err != nil {
+			submission.LateSubmission = unknownSubmissionTime
+			submission.FiletypeError = err.Error()
+			return
+		}
+		submission.DateSubmitted = sub_time.Format("2006-01-02-15-04-05")
+		if no_deadline {
+			submission.LateSubmission = lateNotEvaluated
+			return
+		}
+		student_deadline, extratime := classlist[uun].deadlineAndExtraTime(deadline_time)
+		if isLate(sub_time, student_deadline, extratime) {
+			submission.LateSubmission = "LATE"
+		} else if original, ok := classlist_extratime[uun]; ok && isLate(sub_time, student_deadline, original) {
+			r.logPrintf(LevelNormal, "%s: %s is on time because of a concession, but would be LATE with the extra time in the class list %s\n", uun, submission.ReceiptFilename, concessions[uun].Note)
+		}
+		if opts.HardCutoff > 0 && isLate(sub_time, student_deadline.Add(opts.HardCutoff), extratime) {
+			submission.LateSubmission = beyondCutoff
+		}
+	}
+
+	// Build map of UUN to a slice of Learn submissions, reading the receipts in parallel
+	var learn_files = map[string][]parselearn.Submission{}
+	var num_learn_files int
+	var learn_files_mutex sync.Mutex
+	var uun_mismatches []parselearn.Submission // receipts for a different student from the one in their file name
+	receipt_queue := make(chan string)
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for receipt_file := range receipt_queue {
+				extracted_uun := normaliseUUN(finduun.FindStringSubmatch(receipt_file)[1])
+				
+				// read the Learn receipt file
+				submission, err := parselearn.ParseLearnReceipt(receipt_dirs[receipt_file]+"/"+receipt_file)
+				submission.ExamNumber = classlist[extracted_uun].ExamNumber
+				submission.ExtraTime = classlist[extracted_uun].ExtraTime
+				submission.ReceiptFilename = receipt_file
+				if err != nil {
+					// Reported as a submission with an unreadable date, so the student's files are left alone
+					submission.UUN = extracted_uun
+					submission.LateSubmission = unknownSubmissionTime
+					submission.FiletypeError = "Could not read the receipt: "+err.Error()
+				} else {
+					mark_late(&submission, extracted_uun)
+				}
+				
+				// A receipt that names someone else (e.g. when a TA uploaded for a student) can't be
+				// put down to either of them without checking, so it is left in place and reported
+				if submission.UUN != "" && normaliseUUN(submission.UUN) != extracted_uun {
+					submission.MatriculationError = fmt.Sprintf("Receipt is for %s but the file name has %s", normaliseUUN(submission.UUN), extracted_uun)
+					submission.ToMark = "No - UUN mismatch"
+					learn_files_mutex.Lock()
+					uun_mismatches = append(uun_mismatches, submission)
+					learn_files_mutex.Unlock()
+					continue
+				}
+				// Every report gives the UUN in the same form as the class list, whatever case the receipt uses
+				submission.UUN = extracted_uun
+				
+				// If the submitted file is still being written, leave the student until a later run
+				if submission.Filename != "" {
+					if f, err := os.Stat(receipt_dirs[receipt_file]+"/"+submission.Filename); err == nil && too_recent(f) {
+						learn_files_mutex.Lock()
+						recent_uuns[extracted_uun] = true
+						learn_files_mutex.Unlock()
+						continue
+					}
+				}
+				
+				// If there are already submissions from this student, add them to the list; otherwise start a new list
+				learn_files_mutex.Lock()
+				if _, ok := learn_files[extracted_uun]; ok {
+					learn_files[extracted_uun] = append(learn_files[extracted_uun], submission)					
+				} else {
+					learn_files[extracted_uun] = []parselearn.Submission{submission}
+				}
+				num_learn_files++
+				learn_files_mutex.Unlock()
+			}
+		}()
+	}
+	for _, receipt_file := range receipt_files {
+		receipt_queue <- receipt_file
+	}
+	close(receipt_queue)
+	wg.Wait()
+	
+	// Submissions collected through Turnitin are added alongside the Learn ones, to be chosen between in the same way
+	if opts.TurnitinDir != "" {
+		turnitin_csv := opts.TurnitinCSV
+		if turnitin_csv == "" {
+			turnitin_csv = opts.TurnitinDir+"/index.csv"
+		}
+		r.logPrintln(LevelNormal, "turnitin csv: ", turnitin_csv)
+		turnitin_files, err := readTurnitinIndex(turnitin_csv)
+		if err != nil {
+			return IngestResult{}, fmt.Errorf("%s: %v", turnitin_csv, err)
+		}
+		for _, submission := range turnitin_files {
+			uun := submission.UUN
+			submission.ExamNumber = classlist[uun].ExamNumber
+			submission.ExtraTime = classlist[uun].ExtraTime
+			mark_late(&submission, uun)
+			receipt_dirs[submission.ReceiptFilename] = opts.TurnitinDir
+			learn_files[uun] = append(learn_files[uun], submission)
+			num_learn_files++
+		}
+		r.logPrintln(LevelNormal, "turnitin files: ", len(turnitin_files))
+	}
+	
+	sort.Slice(uun_mismatches, func(i, j int) bool {
+		return uun_mismatches[i].ReceiptFilename < uun_mismatches[j].ReceiptFilename
+	})
+	for _, sub := range uun_mismatches {
+		r.logPrintln(LevelQuiet, "WARNING:", sub.ReceiptFilename, ":", sub.MatriculationError)
+	}
+	
+	// Submissions from anyone not in the class list have no exam number to be named with, so they are
+	// left where they are and reported, in case a student has submitted without being enrolled
+	var unknown_students []parselearn.Submission
+	for uun, student_submissions := range learn_files {
+		if enrolled[uun] {
+			continue
+		}
+		r.logPrintln(LevelQuiet, "WARNING:", uun, "has", len(student_submissions), "submissions but is not in the class list")
+		for _, sub := range student_submissions {
+			sub.ToMark = "No - not in class list"
+			unknown_students = append(unknown_students, sub)
+		}
+		delete(learn_files, uun)
+	}
+	sort.Slice(unknown_students, func(i, j int) bool {
+		return unknown_students[i].ReceiptFilename < unknown_students[j].ReceiptFilename
+	})
+
+	// Sort each student's submissions by receipt file name, so that their order doesn't depend on which worker
+	// finished first
+	for _, student_submissions := range learn_files {
+		sort.Slice(student_submissions, func(i, j int) bool {
+			return student_submissions[i].ReceiptFilename < student_submissions[j].ReceiptFilename
+		})
+	}
+	r.logPrintln(LevelNormal, "learn files: ",num_learn_files, "from", len(learn_files), "students")
+	if opts.Debug {
+		PrettyPrintStruct(learn_files)
+	}
+	
+	// When simulating, just report how many students would be late at each of the candidate deadlines
+	if simulating {
+		var simulations []DeadlineSimulation
+		r.logPrintln(LevelQuiet, "\n\nStudents who would be on time or late at each deadline (with grace and extra time):")
+		for _, candidate := range simulated_deadlines {
+			simulation := simulateDeadline(classlist, learn_files, candidate, r.timeZone)
+			r.logPrintf(LevelQuiet, " - %s: %d on time, %d late, %d with no submission, %d with unreadable dates\n",
+				simulation.Deadline, simulation.OnTime, simulation.Late, simulation.NoSubmission, simulation.Unknown)
+			simulations = append(simulations, simulation)
+		}
+		return IngestResult{Simulations: simulations}, nil
+	}
+		
+/*	
+	// Read the class list csv	
+	csvfile, err := os.Open(classListCSV)
+	if err != nil {
+		log.Fatalln("Couldn't open the csv file", err)
+	}
+	classlistcsv := csv.NewReader(csvfile)
+	
+	var examno = map[string]string{}
+*/
+
+	// Read the list of files uploaded to MS Forms, which is the backup when there is no Learn submission
+	var forms_files = map[string]string{}
+	if formsDir != "" {
+		if formsCSV == "" {
+			formsCSV = formsDir+"/forms.csv"
+		}
+		r.logPrintln(LevelNormal, "forms csv: ", formsCSV)
+		formsFile, err := os.Open(formsCSV)
+		if err != nil {
+			return IngestResult{}, err
+		}
+		forms_raw := []FormsUpload{}
+		err = gocsv.Unmarshal(skipBOM(formsFile), &forms_raw)
+		formsFile.Close()
+		if err != nil {
+			return IngestResult{}, fmt.Errorf("%s: %v", formsCSV, err)
+		}
+		for _, upload := range forms_raw {
+			forms_files[normaliseUUN(upload.StudentID)] = strings.TrimSpace(upload.Filename)
+		}
+		r.logPrintln(LevelNormal, "forms files: ", len(forms_files))
+	}
+
+	// Read the files chosen by hand for particular students, which are used instead of anything else they submitted
+	var override_files = map[string]string{}
+	if opts.Overrides != "" {
+		r.logPrintln(LevelNormal, "overrides csv: ", opts.Overrides)
+		overridesFile, err := os.Open(opts.Overrides)
+		if err != nil {
+			return IngestResult{}, err
+		}
+		overrides_raw := []OverrideFile{}
+		err = gocsv.Unmarshal(skipBOM(overridesFile), &overrides_raw)
+		overridesFile.Close()
+		if err != nil {
+			return IngestResult{}, fmt.Errorf("%s: %v", opts.Overrides, err)
+		}
+		for _, override := range overrides_raw {
+			uun := normaliseUUN(override.StudentID)
+			path := strings.TrimSpace(override.Path)
+			if _, err := os.Stat(path); err != nil {
+				return IngestResult{}, fmt.Errorf("%s: the file given for %s can't be used: %v", opts.Overrides, uun, err)
+			}
+			if _, ok := classlist[uun]; !ok {
+				r.logPrintln(LevelQuiet, "WARNING:", uun, "has an override but is not being processed (they may not be in the class list)")
+			}
+			override_files[uun] = path
+		}
+		r.logPrintln(LevelNormal, "overrides: ", len(override_files))
+	}
+
+	// Prepare data structures to hold the data
+	var submissions []parselearn.Submission
+	var manual_submissions []parselearn.Submission // uun.pdf files put in learndir by hand, which have no Learn timestamp
+	var bad_submissions []parselearn.Submission
+	var empty_submissions []parselearn.Submission // receipts that list no files at all
+	var rejected_submissions []parselearn.Submission // submissions made after -hardcutoff, which are never used
+	var wrong_format_submissions []parselearn.Submission // single files that are really Office documents or images
+	var too_many_files []parselearn.Submission // bad submissions with more files than -maxmergefiles
+	var no_submissions []parselearn.Submission
+	var already_done []parselearn.Submission
+	var still_being_written []parselearn.Submission // students with files newer than -minage, left for a later run
+	var conflicts []parselearn.Submission // submissions that were not used because -nooverwrite kept an existing output file
+	var manifest []ManifestEntry
+	var extra_time_usage ExtraTimeUsage
+	var submission_times SubmissionTimes
+	var submission_summaries []SubmissionSummary
+	var output_details = map[string]SuccessfulSubmission{} // checksum and length of each successful student's output file
+	var submitted_checksums = map[string]string{} // checksum of each output file before a cover sheet was added to it
+	
+	// When resuming, the checksums from earlier runs are used to check the output files left in place
+	var previous_checksums map[string]string
+	if opts.Resume {
+		previous_checksums = r.readPreviousChecksums(outputDir+"/reports")
+	}
+
+	// With -anonymisereceipts, kept receipts are moved to outputDir/receipts under the output file's name.
+	// They can't stay in the input folders, as the next run would then take them for Learn receipts
+	receipts_dir := outputDir+"/receipts"
+	keep_receipt := func(path string, new_name string) {
+		if err := ensureDir(receipts_dir); err != nil {
+			r.logPrintln(LevelQuiet, "WARNING: could not make", receipts_dir, "so", path, "has been left in place:", err)
+			return
+		}
+		r.moveFile(path, receipts_dir+"/"+new_name)
+	}
+	
+	// Once a submission has been dealt with, its receipt is removed, or with -anonymisereceipts kept under new_name.
+	// Submissions from Turnitin have no receipt file, so there is nothing to do for them
+	drop_receipt := func(sub parselearn.Submission, dir string, new_name string) {
+		if isTurnitin(sub) {
+			return
+		}
+		if !opts.KeepReceipts {
+			r.removeFile(dir+"/"+sub.ReceiptFilename)
+		} else if opts.AnonymiseReceipts {
+			keep_receipt(dir+"/"+sub.ReceiptFilename, new_name)
+		}
+	}
+
+	//
+	// Identify the submission for each student in the class list
+	//
+	// Submissions read from a Turnitin export are chosen in the same way as Learn ones, but labelled differently
+	learn_source := func(sub parselearn.Submission) string {
+		if isTurnitin(sub) {
+			return sourceTurnitin
+		}
+		return sourceLearn
+	}
+	
+	// With -coversheet, each new output file starts with a page for the marker giving the exam number and
+	// the details of the submission (but nothing that identifies the student)
+	add_cover_sheet := func(path string, sub parselearn.Submission, status string) {
+		if !opts.CoverSheet || (status != "File created" && status != "File replaced") {
+			return
+		}
+		pages := r.describeOutput(path, "").Pages
+		if pages == "" {
+			pages = "unknown"
+		}
+		submitted := sub.DateSubmitted
+		if submitted == "" {
+			submitted = "unknown"
+		}
+		late := "On time"
+		switch sub.LateSubmission {
+		case "LATE":
+			late = "LATE"
+		case lateNotEvaluated:
+			late = lateNotEvaluated
+		}
+		lines := []string{
+			"Course: "+courseCode,
+			"Pages: "+pages+" (not counting this cover sheet)",
+			"Submitted: "+submitted,
+			"Late: "+late,
+		}
+		// The cover sheet makes every file different, so keep the checksum of what was submitted for finding duplicates
+		submitted_checksums[normaliseUUN(sub.UUN)] = r.outputChecksum(path)
+		if err := prependCoverSheet(path, "Exam number "+sub.ExamNumber, lines, r.outputMode); err != nil {
+			r.logPrintln(LevelQuiet, "WARNING: could not add a cover sheet to", path, ":", err)
+		}
+	}
+	
+	// With -keeplate, late submissions that aren't used are kept in outputDir/late for any appeal, named
+	// after the student's output file and the time they were submitted, rather than being deleted
+	late_dir := outputDir+"/late"
+	keep_late := func(sub parselearn.Submission, receipt_base string) {
+		if err := ensureDir(late_dir); err != nil {
+			r.logPrintln(LevelQuiet, "WARNING: could not make", late_dir, "so", sub.ReceiptFilename, "has been left in place:", err)
+			return
+		}
+		late_base := late_dir+"/"+receipt_base+"_"+sub.DateSubmitted
+		if sub.Filename != "" {
+			if _, err := r.moveFile(receipt_dirs[sub.ReceiptFilename]+"/"+sub.Filename, late_base+filepath.Ext(sub.Filename)); err != nil {
+				// The receipt stays with the file, so that the two can still be matched up
+				return
+			}
+		}
+		if !isTurnitin(sub) {
+			r.moveFile(receipt_dirs[sub.ReceiptFilename]+"/"+sub.ReceiptFilename, late_base+".txt")
+		}
+	}
+	
+	// Go through the students in a fixed order, so that the reports from different runs can be compared
+	var student_order []string
+	for uun := range classlist {
+		student_order = append(student_order, uun)
+	}
+	sort.Slice(student_order, func(i, j int) bool {
+		a, b := classlist[student_order[i]], classlist[student_order[j]]
+		if opts.SortBy == "examno" && a.ExamNumber != b.ExamNumber {
+			return a.ExamNumber < b.ExamNumber
+		}
+		return a.StudentID < b.StudentID
+	})
+	
+	// The name of a student's output file, with the extension of what is in it whatever the template ends
+	// in - so always .pdf, since only PDFs are moved into place, except for an override that is not a PDF
+	output_filename := func(name OutputName, ext string) string {
+		filename, _ := outputFilename(output_template, name) // checked for each student before it is used
+		return withExtension(filename, ext)
+	}
+	
+	// The path of an output file made on an earlier run, or blank if there isn't one. With -inplace it
+	// is in whichever input folder it was renamed in, rather than outputDir
+	existing_path := func(name string) string {
+		dir := outputDir
+		if opts.InPlace {
+			dir = inplace_outputs[name]
+			if dir == "" {
+				return ""
+			}
+		}
+		if _, err := os.Stat(dir+"/"+name); err != nil {
+			return ""
+		}
+		return dir+"/"+name
+	}
+	
+	// Where to put an output file: in outputDir, or with -inplace, where the earlier output file
+	// of that name is if there is one, otherwise in the folder the submission is in
+	output_path := func(source_dir string, name string) string {
+		if opts.InPlace {
+			if path := existing_path(name); path != "" {
+				return path
+			}
+			return source_dir+"/"+name
+		}
+		return outputDir+"/"+name
+	}
+	
+	// The name of the student's output file, on time or late, if there is a readable one already
+	existing_output := func(output_name OutputName) string {
+		late_name := output_name
+		late_name.Late = true
+		for _, name := range []string{output_filename(output_name, ".pdf"), lateFilename(output_filename(late_name, ".pdf"), latePrefix, lateSuffix)} {
+			if path := existing_path(name); path != "" && checkPDF(path) == nil {
+				return name
+			}
+		}
+		return ""
+	}
+	
+	// From here on files are moved, so an error is no longer one that left everything as it was
+	r.started = true
+	progress := newProgressReporter(r.logger, "student", len(classlist))
+	for _, uun := range student_order {
+		student := classlist[uun]
+		progress.step()
+		
+		student_uun := student.StudentID // already normalised when the class list was read
+		student_examno := student.ExamNumber
+		extratime := student.ExtraTime
+		student_deadline := student.deadline(deadline_time)
+		output_name := OutputName{Course: courseCode, ExamNumber: student_examno, UUN: student_uun, Sequence: sequence_numbers[student_examno]}
+		
+		// The template was tried out before starting, but could still fail on a student's own details
+		late_output_name := output_name
+		late_output_name.Late = true
+		_, err := outputFilename(output_template, output_name)
+		if err == nil {
+			_, err = outputFilename(output_template, late_output_name)
+		}
+		if err != nil {
+			r.logPrintln(LevelQuiet, "WARNING: could not make the output file name for", student_uun, ":", err)
+			name_sub := parselearn.Submission{}
+			name_sub.UUN = student_uun
+			name_sub.ExamNumber = student_examno
+			name_sub.ToMark = "Bad submission"
+			name_sub.FiletypeError = "Could not make the output file name: "+err.Error()
+			bad_submissions = append(bad_submissions, name_sub)
+			continue
+		}
+		
+		// With -reportsonly, the reports are made from the output files already in place, and nothing is touched
+		if opts.ReportsOnly {
+			existing_sub := parselearn.Submission{}
+			existing_sub.UUN = student_uun
+			existing_sub.ExamNumber = student_examno
+			if existing_name := existing_output(output_name); existing_name != "" {
+				r.logPrintln(LevelVerbose, student_uun, "->", student_examno, "has output file", existing_name)
+				existing_sub.OutputFile = existing_name
+				existing_sub.ToMark = "Yes"
+				if existing_name != output_filename(output_name, ".pdf") {
+					existing_sub.LateSubmission = "LATE"
+				}
+				submissions = append(submissions, existing_sub)
+				output_details[student_uun] = r.describeOutput(existing_path(existing_name), sourceExisting)
+			} else {
+				no_submissions = append(no_submissions, existing_sub)
+			}
+			continue
+		}
+		
+		// When resuming, students who already have an on-time output file are left alone
+		if opts.Resume {
+			done_name := output_filename(output_name, ".pdf")
+			if done_path := existing_path(done_name); done_path != "" {
+				r.logPrintln(LevelVerbose, student_uun, "->", student_examno, "already done:", done_name)
+				if previous, ok := previous_checksums[student_uun]; ok {
+					if current := r.outputChecksum(done_path); current != "" && current != previous {
+						r.logPrintln(LevelQuiet, "WARNING:", done_name, "has changed since it was made - its checksum no longer matches the earlier success report")
+					}
+				}
+				done_sub := parselearn.Submission{}
+				done_sub.UUN = student_uun
+				done_sub.ExamNumber = student_examno
+				done_sub.OutputFile = done_name
+				already_done = append(already_done, done_sub)
+				continue
+			}
+		}
+		
+		// A file chosen by hand is used whatever the student submitted, leaving their other files where they are.
+		// It is copied rather than moved, so it is applied again (replacing the output) on every run
+		if override_path, ok := override_files[student_uun]; ok {
+			override_sub := parselearn.Submission{}
+			override_sub.UUN = student_uun
+			override_sub.ExamNumber = student_examno
+			override_sub.Filename = override_path
+			override_sub.ToMark = "Yes"
+			// The output file is named for what the file really is, so that it can be opened
+			override_ext, err := detectExtension(override_path)
+			if err != nil || override_ext == "" {
+				override_ext = filepath.Ext(override_path)
+			}
+			if override_ext != ".pdf" {
+				r.logPrintln(LevelQuiet, "WARNING: the override for", student_uun, "is not a PDF, but", describeExtension(override_ext))
+			}
+			new_path := output_path(filepath.Dir(override_path), output_filename(output_name, override_ext))
+			filemovestatus, err := r.copyOverride(override_path, new_path)
+			if err != nil {
+				r.logPrintln(LevelNormal, " --- Bad override for", student_uun, ": ", err)
+				override_sub.ToMark = "Bad submission"
+				override_sub.FiletypeError = err.Error()
+				bad_submissions = append(bad_submissions, override_sub)
+				continue
+			}
+			override_sub.OutputFile = filemovestatus
+			add_cover_sheet(new_path, override_sub, filemovestatus)
+			r.logPrintf(LevelVerbose, "%s -> %s (override)\n --- %s\n", student_uun, student_examno, filemovestatus)
+			submissions = append(submissions, override_sub)
+			output_details[student_uun] = r.describeOutput(new_path, sourceOverride)
+			manifest = append(manifest, newManifestEntry(override_sub, new_path))
+			continue
+		}
+		
+		// Students with files that may still be being written are left for a later run, so
+		// that none of their submissions are used until all of them can be read
+		if recent_uuns[student_uun] {
+			r.logPrintln(LevelNormal, " ---", student_uun, "has files modified in the last", opts.MinAge, "- leaving them until a later run")
+			recent_sub := parselearn.Submission{}
+			recent_sub.UUN = student_uun
+			recent_sub.ExamNumber = student_examno
+			recent_sub.OutputFile = "Still being written"
+			still_being_written = append(still_being_written, recent_sub)
+			continue
+		}
+		
+		// Students who only have receipts from before -since are left exactly as they were
+		if _, ok := learn_files[student_uun]; !ok && unchanged_uuns[student_uun] {
+			r.logPrintln(LevelVerbose, student_uun, "->", student_examno, "unchanged since", since_time.Format("2006-01-02 at 15:04:05"))
+			unchanged_sub := parselearn.Submission{}
+			unchanged_sub.UUN = student_uun
+			unchanged_sub.ExamNumber = student_examno
+			unchanged_sub.OutputFile = "Unchanged"
+			already_done = append(already_done, unchanged_sub)
+			continue
+		}
+		
+		// Check their submissions to Learn
+		if student_submissions, ok := learn_files[student_uun]; ok {
+			r.logPrintf(LevelVerbose, "%s -> %s (extra time: %d)\n", student_uun, student_examno, extratime)
+			
+			// A student known to have an accommodation but no extra time in the class list may
+			// have been missed when the class list was put together, so would be wrongly late
+			if accommodations[student_uun] && extratime == 0 && student.DeadlineTime.IsZero() {
+				for _, sub := range student_submissions {
+					if sub.LateSubmission == "LATE" || sub.LateSubmission == beyondCutoff {
+						r.logPrintln(LevelQuiet, "WARNING:", student_uun, "is on the accommodations list but has no extra time in the class list, and has a LATE submission:", sub.ReceiptFilename)
+						missing_extra_time = append(missing_extra_time, student_uun)
+						break
+					}
+				}
+			}
+			
+			// If the time of any submission couldn't be read, there's no telling which should be used
+			// or whether it was late, so the student's submissions have to be looked at by hand
+			unreadable := -1
+			for i, sub := range student_submissions {
+				if sub.LateSubmission == unknownSubmissionTime {
+					unreadable = i
+					break
+				}
+			}
+			if unreadable >= 0 {
+				r.logPrintln(LevelNormal, " ---", student_uun, "has a submission with an unreadable date:", student_submissions[unreadable].ReceiptFilename)
+				bad_sub := student_submissions[unreadable]
+				bad_sub.ToMark = "Bad submission"
+				submission_summaries = append(submission_summaries, newSubmissionSummary(bad_sub, student_deadline, r.timeZone))
+				bad_submissions = append(bad_submissions, bad_sub)
+				continue
+			}
+			
+			// Find the last non-LATE submission among student_submissions (or the last of any when accepting late work)
+			submission := parselearn.Submission{}
+			submission.DateSubmitted = dummyDateSubmitted // a dummy time well in the past
+			submission_time, _ := time.ParseInLocation("2006-01-02-15-04-05", submission.DateSubmitted, r.timeZone)
+			submission.LateSubmission = "LATE" // this will appear in the report if there are no on-time submissions
+			var superseded []int // positions in submission_summaries of this student's superseded submissions
+			
+			// Kept receipts can be named after the output file, so they no longer show the UUN
+			receipt_base := strings.TrimSuffix(output_filename(output_name, ".pdf"), ".pdf")
+			for _, sub := range student_submissions {
+				if sub.LateSubmission == beyondCutoff {
+					// too late to be marked at all, so reject it whatever the other settings
+					r.logPrintln(LevelVerbose, " -- Rejected submission beyond the cutoff: ", sub.ReceiptFilename)
+					sub.ToMark = "No - beyond cutoff"
+					submission_summaries = append(submission_summaries, newSubmissionSummary(sub, student_deadline, r.timeZone))
+					rejected_submissions = append(rejected_submissions, sub)
+					if opts.KeepLate {
+						keep_late(sub, receipt_base)
+						continue
+					}
+					if !opts.KeepReceipts && !isTurnitin(sub) {
+						r.removeFile(receipt_dirs[sub.ReceiptFilename]+"/"+sub.ReceiptFilename)
+					}
+					if sub.Filename != "" {
+						r.removeFile(receipt_dirs[sub.ReceiptFilename]+"/"+sub.Filename)
+					}
+					continue
+				}
+				if sub.LateSubmission == "LATE" && !opts.AcceptLate {
+					// skip any LATE submissions
+					r.logPrintln(LevelVerbose, " -- Skipped LATE submission: ", sub.ReceiptFilename)
+					sub.ToMark = "No - LATE"
+					submission_summaries = append(submission_summaries, newSubmissionSummary(sub, student_deadline, r.timeZone))
+					if opts.KeepLate {
+						keep_late(sub, receipt_base)
+						continue
+					}
+					drop_receipt(sub, receipt_dirs[sub.ReceiptFilename], receipt_base+"_"+sub.DateSubmitted+".txt")
+					if sub.Filename != "" {
+						r.removeFile(receipt_dirs[sub.ReceiptFilename]+"/"+sub.Filename)
+					}
+					continue
+				}
+				sub_time, _ := time.ParseInLocation("2006-01-02-15-04-05", sub.DateSubmitted, r.timeZone)
+				if sub_time.After(submission_time) {
+					// submission is superseded by sub - so remove files for submission
+					if submission.ReceiptFilename != "" {
+						r.logPrintln(LevelVerbose, " -- Skipped submission: ", submission.ReceiptFilename)
+						submission.ToMark = "No - Superseded"						
+						superseded = append(superseded, len(submission_summaries))
+						submission_summaries = append(submission_summaries, newSubmissionSummary(submission, student_deadline, r.timeZone))
+						drop_receipt(submission, receipt_dirs[submission.ReceiptFilename], receipt_base+"_"+submission.DateSubmitted+".txt")
+						if submission.Filename != "" {
+							r.removeFile(receipt_dirs[submission.ReceiptFilename]+"/"+submission.Filename)
+						}
+					}
+					// update submission with the more recent sub
+					submission = sub
+					submission_time = sub_time
+				}				
+			}
+			
+			// Note which submission replaced the superseded ones
+			for _, i := range superseded {
+				submission_summaries[i].SupersededBy = submission.ReceiptFilename
+			}
+			
+			// Keep track of how close to the deadline the submission used was made
+			base_deadline, _ := student.deadlineAndExtraTime(deadline_time)
+			if submission.ReceiptFilename != "" && !no_deadline {
+				submission_times.add(submission_time, base_deadline, extratime)
+			}
+			
+			// Keep track of how students with extra time (rather than their own deadline) used it
+			if extratime > 0 && student.DeadlineTime.IsZero() && !no_deadline {
+				switch {
+				case submission.ReceiptFilename == "" || submission.LateSubmission == "LATE":
+					extra_time_usage.RanOver++
+				case submission_time.After(base_deadline):
+					extra_time_usage.UsedExtraTime++
+				default:
+					extra_time_usage.BeforeDeadline++
+				}
+			}
+			
+			// If none of the student's submissions could be used (because they were LATE), note that fact
+			if submission.ReceiptFilename == "" {
+				r.logPrintln(LevelNormal, " ---", student_uun, "has no on-time submission.")
+				bad_submissions = append(bad_submissions, student_submissions[0])
+				continue
+			}
+			
+			// A receipt with no files means the student pressed submit without attaching anything,
+			// which needs the student chasing up rather than files sorting out, so report it separately
+			if submission.NumberOfFiles == 0 {
+				r.logPrintln(LevelNormal, " ---", student_uun, "made an empty submission:", submission.ReceiptFilename)
+				submission.ToMark = "Empty submission"
+				submission_summaries = append(submission_summaries, newSubmissionSummary(submission, student_deadline, r.timeZone))
+				empty_submissions = append(empty_submissions, submission)
+				continue
+			}
+			
+			// The submitted files are in the same folder as the receipt
+			submission_dir := receipt_dirs[submission.ReceiptFilename]
+			
+			// Make sure a single PDF is not empty, and can actually be opened by markers
+			wrong_format := false // an Office document or image, probably renamed to .pdf
+			output_ext := ".pdf" // the extension of the output file, from what is really in the submitted file
+			if submission.NumberOfFiles == 1 && submission.FiletypeError == "" {
+				file_ext, ext_err := detectExtension(submission_dir+"/"+submission.Filename)
+				if ext_err == nil && file_ext != "" {
+					output_ext = file_ext
+				}
+				if info, err := os.Stat(submission_dir+"/"+submission.Filename); err == nil && info.Size() < minBytes {
+					submission.FiletypeError = fmt.Sprintf("File is too small (%d bytes)", info.Size())
+				} else if ext_err == nil && file_ext != ".pdf" {
+					submission.FiletypeError = fmt.Sprintf("File is not a PDF (the contents look like %s)", describeExtension(file_ext))
+					wrong_format = isWrongFormat(file_ext)
+				} else if err := checkPDF(submission_dir+"/"+submission.Filename); err != nil && !os.IsNotExist(err) {
+					submission.FiletypeError = err.Error()
+				} else if ext_err == nil && !hasPDFExtension(submission.Filename) {
+					// It really is a PDF, and the output file is named .pdf, but the student named it differently
+					r.logPrintln(LevelNormal, " --- WARNING:", student_uun, "submitted a PDF named", submission.Filename, "- the output file is named .pdf")
+				}
+			}
+			
+			// A submission of a great many files (e.g. a photo of each page) needs different handling from a few that can be merged
+			if maxMergeFiles > 0 && submission.NumberOfFiles > maxMergeFiles && submission.FiletypeError == "" {
+				submission.FiletypeError = fmt.Sprintf("Too many files (%d)", submission.NumberOfFiles)
+				too_many_files = append(too_many_files, submission)
+			}
+			
+			// When asked, several PDFs from one submission are merged into one, in the order they were uploaded
+			source_path := submission_dir+"/"+submission.Filename
+			var merged_parts []string
+			if opts.MergePDFs && submission.NumberOfFiles > 1 && submission.FiletypeError == "" {
+				merged_path, parts, err := mergeSubmission(submission_dir, submission)
+				if err != nil {
+					submission.FiletypeError = "Could not merge files: "+err.Error()
+				} else {
+					r.logPrintln(LevelVerbose, " -- Merged", len(parts), "files")
+					source_path = merged_path
+					merged_parts = parts
+				}
+			}
+			
+			if (submission.NumberOfFiles == 1 || merged_parts != nil) && submission.FiletypeError == "" {
+			
+				// We have one PDF for the student, so move it into place in the outputDir
+				
+				r.logPrintln(LevelVerbose, " -- Using Submission:   ",submission.Filename)
+				submission.ToMark = "Yes"
+				is_late := submission.LateSubmission == "LATE"
+				late_name := output_name
+				late_name.Late = is_late
+				new_name := output_filename(late_name, output_ext)
+				new_path := output_path(submission_dir, new_name)
+				if is_late {
+					new_path = output_path(submission_dir, lateFilename(new_name, latePrefix, lateSuffix))
+				}
+				// When receipts are kept, the file may already have been moved on an earlier run
+				if _, err := os.Stat(source_path); opts.KeepReceipts && os.IsNotExist(err) {
+					submission.OutputFile = "Already moved"
+					r.logPrintln(LevelVerbose, " --- ", submission.OutputFile)
+					submission_summaries = append(submission_summaries, newSubmissionSummary(submission, student_deadline, r.timeZone))
+					submissions = append(submissions, submission)
+					output_details[student_uun] = r.describeOutput(new_path, learn_source(submission))
+					manifest = append(manifest, newManifestEntry(submission, new_path))
+					continue
+				}
+				filemovestatus, err := r.moveFile(source_path, new_path)
+				if merged_parts != nil {
+					// The merged file is only temporary
+					os.Remove(source_path)
+				}
+				if err != nil {
+					// The submitted file couldn't be copied, so it is left in place to be looked at by hand
+					r.logPrintln(LevelNormal, " --- Bad submission from", student_uun, ": ", err)
+					submission.ToMark = "Bad submission"
+					submission.FiletypeError = err.Error()
+					submission_summaries = append(submission_summaries, newSubmissionSummary(submission, student_deadline, r.timeZone))
+					bad_submissions = append(bad_submissions, submission)
+					continue
+				}
+				submission.OutputFile = filemovestatus
+				r.logPrintln(LevelVerbose, " --- ", filemovestatus)
+				if filemovestatus == outputConflict {
+					// The existing output file is the one that will be marked, so this submission is only
+					// listed as a conflict, and left in place with its receipt
+					submission.ToMark = "No - existing output kept"
+					submission_summaries = append(submission_summaries, newSubmissionSummary(submission, student_deadline, r.timeZone))
+					conflicts = append(conflicts, submission)
+					continue
+				}
+				submission_summaries = append(submission_summaries, newSubmissionSummary(submission, student_deadline, r.timeZone))
+				add_cover_sheet(new_path, submission, filemovestatus)
+				
+				// The file move was OK, so we can remove the files that were merged, and the Learn receipt as it's no longer needed
+				for _, part := range merged_parts {
+					r.removeFile(part)
+				}
+				drop_receipt(submission, submission_dir, receipt_base+".txt")
+				
+				// Add this record to the table of successes, and to the manifest
+				submissions = append(submissions, submission)
+				output_details[student_uun] = r.describeOutput(new_path, learn_source(submission))
+				manifest_entry := newManifestEntry(submission, new_path)
+				if merged_parts != nil {
+					var part_names []string
+					for _, part := range merged_parts {
+						part_names = append(part_names, filepath.Base(part))
+					}
+					manifest_entry.Filename = strings.Join(part_names, ";")
+				}
+				manifest = append(manifest, manifest_entry)
+				
+			} else if wrong_format {
+				// The student needs to be asked to submit it again as a PDF
+				r.logPrintln(LevelNormal, " --- Wrong format from", student_uun, ": ", submission.FiletypeError)
+				submission.ToMark = "Wrong format"
+				submission_summaries = append(submission_summaries, newSubmissionSummary(submission, student_deadline, r.timeZone))
+				wrong_format_submissions = append(wrong_format_submissions, submission)
+			} else {
+				// There was a problem with this submission, so it will need investigation and manual work
+				
+				r.logPrintln(LevelNormal, " --- Bad submission from", student_uun, ": ",submission.NumberOfFiles, " files ", submission.FiletypeError)
+				submission.ToMark = "Bad submission"
+				submission_summaries = append(submission_summaries, newSubmissionSummary(submission, student_deadline, r.timeZone))
+				bad_submissions = append(bad_submissions, submission)					
+			}
+			
+			// Done - move on to next student
+			continue
+		}
+		
+		// At this point they did not submit to Learn - check for an upload to MS Forms
+		if forms_file, ok := forms_files[student_uun]; ok {
+			forms_path := formsDir+"/"+forms_file
+			if _, err := os.Stat(forms_path); err == nil {
+				forms_sub := parselearn.Submission{}
+				forms_sub.UUN = student_uun
+				forms_sub.ExamNumber = student_examno
+				forms_sub.Filename = forms_file
+				if file_ext, err := detectExtension(forms_path); err == nil && file_ext != ".pdf" {
+					// Forms takes any file, so make sure it really is a PDF before it is moved into place
+					r.logPrintln(LevelNormal, " --- Bad MS Forms upload from", student_uun, ": not a PDF, but", describeExtension(file_ext))
+					forms_sub.ToMark = "Bad submission"
+					forms_sub.FiletypeError = fmt.Sprintf("File is not a PDF (the contents look like %s)", describeExtension(file_ext))
+					bad_submissions = append(bad_submissions, forms_sub)
+					continue
+				}
+				new_path := output_path(filepath.Dir(forms_path), output_filename(output_name, ".pdf"))
+				filemovestatus, err := r.moveFile(forms_path, new_path)
+				if err != nil {
+					r.logPrintln(LevelNormal, " --- Bad MS Forms upload from", student_uun, ": ", err)
+					forms_sub.ToMark = "Bad submission"
+					forms_sub.FiletypeError = err.Error()
+					bad_submissions = append(bad_submissions, forms_sub)
+					continue
+				}
+				forms_sub.OutputFile = filemovestatus
+				add_cover_sheet(new_path, forms_sub, filemovestatus)
+				if filemovestatus == outputConflict {
+					forms_sub.ToMark = "No - existing output kept"
+					conflicts = append(conflicts, forms_sub)
+					continue
+				}
+				r.logPrintf(LevelVerbose, "%s -> %s (MS Forms)\n --- %s\n", student_uun, student_examno, filemovestatus)
+				submissions = append(submissions, forms_sub)
+				output_details[student_uun] = r.describeOutput(new_path, sourceForms)
+				manifest = append(manifest, newManifestEntry(forms_sub, new_path))
+				
+				// Done - move on to next student
+				continue
+			}
+			r.logPrintln(LevelNormal, " ---", student_uun, "has an MS Forms upload listed but no file at", forms_path)
+		}
+		
+		// Otherwise check for a raw UUN.pdf
+		raw_uun_path := learnDir+"/"+strings.ToLower(student_uun)+".pdf"
+		if _, err := os.Stat(raw_uun_path); err == nil {
+			// Such a file exists, so create a dummy Submission for it and then move the PDF into place
+			manual_sub := parselearn.Submission{}
+			manual_sub.UUN = student_uun
+			manual_sub.ExamNumber = student_examno
+			manual_sub.Filename = filepath.Base(raw_uun_path)
+			if file_ext, err := detectExtension(raw_uun_path); err == nil && file_ext != ".pdf" {
+				// The file may have been renamed to uun.pdf by hand without being converted
+				r.logPrintln(LevelNormal, " --- Bad manual submission from", student_uun, ": not a PDF, but", describeExtension(file_ext))
+				manual_sub.ToMark = "Bad submission"
+				manual_sub.FiletypeError = fmt.Sprintf("File is not a PDF (the contents look like %s)", describeExtension(file_ext))
+				bad_submissions = append(bad_submissions, manual_sub)
+				continue
+			}
+			new_path := output_path(learnDir, output_filename(output_name, ".pdf"))
+			filemovestatus, err := r.moveFile(raw_uun_path, new_path)
+			if err != nil {
+				r.logPrintln(LevelNormal, " --- Bad manual submission from", student_uun, ": ", err)
+				manual_sub.ToMark = "Bad submission"
+				manual_sub.FiletypeError = err.Error()
+				bad_submissions = append(bad_submissions, manual_sub)
+				continue
+			}
+			manual_sub.OutputFile = filemovestatus
+			add_cover_sheet(new_path, manual_sub, filemovestatus)
+			if filemovestatus == outputConflict {
+				manual_sub.ToMark = "No - existing output kept"
+				conflicts = append(conflicts, manual_sub)
+				continue
+			}
+			manual_submissions = append(manual_submissions, manual_sub)
+			output_details[student_uun] = r.describeOutput(new_path, sourceManual)
+			manifest = append(manifest, newManifestEntry(manual_sub, new_path))
+			
+			// Done - move on to next student
+			continue
+		}
+		
+		// The student's submission may have been moved into place on an earlier run, leaving nothing
+		// behind in learndir, in which case they are already done rather than missing
+		if done_name := existing_output(output_name); done_name != "" {
+			r.logPrintln(LevelVerbose, student_uun, "->", student_examno, "already processed:", done_name)
+			done_sub := parselearn.Submission{}
+			done_sub.UUN = student_uun
+			done_sub.ExamNumber = student_examno
+			done_sub.OutputFile = done_name
+			already_done = append(already_done, done_sub)
+			continue
+		}
+		
+		// Now there is really no submission from this student, so record that fact
+		sub := parselearn.Submission{}
+		sub.UUN = student_uun
+		sub.ExamNumber = student_examno
+		sub.NumberOfFiles = 0
+		no_submissions = append(no_submissions, sub)
+	
+	}
+	
+	// Identical files from different students are worth a look, e.g. someone submitting another's file, or the blank template
+	content_checksums := map[string]string{}
+	for uun, details := range output_details {
+		content_checksums[uun] = details.Checksum
+		if sum, ok := submitted_checksums[uun]; ok {
+			content_checksums[uun] = sum
+		}
+	}
+	var duplicates []DuplicateContent
+	for sum, uuns := range duplicateChecksums(content_checksums) {
+		for _, uun := range uuns {
+			duplicates = append(duplicates, DuplicateContent{Checksum: sum, UUN: uun, ExamNumber: classlist[uun].ExamNumber, OutputPath: output_details[uun].OutputPath})
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		if duplicates[i].Checksum != duplicates[j].Checksum {
+			return duplicates[i].Checksum < duplicates[j].Checksum
+		}
+		return duplicates[i].UUN < duplicates[j].UUN
+	})
+	
+	r.logPrintln(LevelNormal, "\n\nSuccessful submissions: ", len(submissions))
+	r.logPrintln(LevelNormal, "\n\nManual submissions: ", len(manual_submissions))
+	r.logPrintln(LevelNormal, "\n\nBad submissions: ", len(bad_submissions))
+	if len(too_many_files) > 0 {
+		r.logPrintln(LevelNormal, "   (of which with too many files to merge: ", len(too_many_files), ")")
+	}
+	r.logPrintln(LevelNormal, "\n\nWrong format submissions: ", len(wrong_format_submissions))
+	r.logPrintln(LevelNormal, "\n\nEmpty submissions: ", len(empty_submissions))
+	r.logPrintln(LevelNormal, "\n\nNo submissions: ", len(no_submissions))
+	if len(already_done) > 0 {
+		r.logPrintln(LevelNormal, "\n\nAlready done: ", len(already_done))
+	}
+	if len(still_being_written) > 0 {
+		r.logPrintln(LevelQuiet, "\n\nStudents left for a later run, as their files may still be being written: ", len(still_being_written))
+	}
+	if len(missing_examno) > 0 {
+		r.logPrintln(LevelQuiet, "\n\nStudents with no exam number: ", len(missing_examno))
+	}
+	if len(withdrawn) > 0 {
+		r.logPrintln(LevelNormal, "\n\nWithdrawn students (not processed): ", len(withdrawn))
+	}
+	if len(rejected_submissions) > 0 {
+		r.logPrintln(LevelNormal, "\n\nSubmissions rejected for being beyond the cutoff: ", len(rejected_submissions))
+	}
+	if len(conflicts) > 0 {
+		r.logPrintln(LevelQuiet, "\n\nSubmissions not used because an output file already exists: ", len(conflicts))
+	}
+	if len(unknown_students) > 0 {
+		r.logPrintln(LevelQuiet, "\n\nSubmissions from students not in the class list (left in place): ", len(unknown_students))
+	}
+	if len(missing_extra_time) > 0 {
+		r.logPrintln(LevelQuiet, "\n\nStudents on the accommodations list with no extra time in the class list, marked LATE (check the class list): ", len(missing_extra_time))
+		for _, uun := range missing_extra_time {
+			r.logPrintln(LevelQuiet, " - ", uun, "(", classlist[uun].ExamNumber, ")")
+		}
+	}
+	if len(uun_mismatches) > 0 {
+		r.logPrintln(LevelQuiet, "\n\nReceipts for a different UUN from their file name (left in place): ", len(uun_mismatches))
+	}
+	if len(duplicates) > 0 {
+		r.logPrintln(LevelQuiet, "\n\nStudents whose submitted file is identical to another student's: ", len(duplicates))
+		for _, duplicate := range duplicates {
+			r.logPrintln(LevelQuiet, " - ", duplicate.UUN, "(", duplicate.ExamNumber, ")", duplicate.Checksum[:12])
+		}
+	}
+	if !no_deadline {
+		r.logPrintln(LevelNormal, "\n\nStudents with extra time who submitted:")
+		r.logPrintln(LevelNormal, " - before the normal deadline: ", extra_time_usage.BeforeDeadline)
+		r.logPrintln(LevelNormal, " - using some of their extra time: ", extra_time_usage.UsedExtraTime)
+		r.logPrintln(LevelNormal, " - after their extra time ran out: ", extra_time_usage.RanOver)
+		
+		r.logPrintln(LevelNormal, "\n\nTimes of the submissions used, relative to each student's deadline:")
+		buckets := submission_times.buckets()
+		largest := 1
+		for _, bucket := range buckets {
+			if bucket.Students > largest {
+				largest = bucket.Students
+			}
+		}
+		for _, bucket := range buckets {
+			// Draw a bar for each, scaled so the largest is 40 characters long
+			r.logPrintf(LevelNormal, " - %-24s %4d %s\n", bucket.Bucket+":", bucket.Students, strings.Repeat("#", bucket.Students*40/largest))
+		}
+	}
+	
+	r.logPrintf(LevelNormal, "\n\nprocessed %d files, %.1f MB\n", r.processedFiles, float64(r.processedBytes)/(1024*1024))
+	
+	// In strict mode every student must have submitted, so make sure any who didn't are noticed
+	if opts.Strict && len(no_submissions)+len(empty_submissions) > 0 {
+		r.logPrintln(LevelQuiet, "\n\n**********")
+		r.logPrintln(LevelQuiet, "ERROR:", len(no_submissions)+len(empty_submissions), "students have no submission (or an empty one):")
+		for _, sub := range append(no_submissions, empty_submissions...) {
+			r.logPrintln(LevelQuiet, " - ", sub.UUN, "(", sub.ExamNumber, ")")
+		}
+		r.logPrintln(LevelQuiet, "**********")
+	}
+	
+	// Check the numbers add up - every student in the class list should be in exactly one of the reports
+	reconciliation := Reconciliation{
+		ClassList:         len(classlist),
+		Successful:        len(submissions),
+		Manual:            len(manual_submissions),
+		Bad:               len(bad_submissions),
+		WrongFormat:       len(wrong_format_submissions),
+		Empty:             len(empty_submissions),
+		NoSubmission:      len(no_submissions),
+		AlreadyDone:       len(already_done),
+		StillBeingWritten: len(still_being_written),
+		Conflicts:         len(conflicts),
+	}
+	if !reconciliation.Balanced() {
+		r.logPrintln(LevelQuiet, "\n\n**********")
+		r.logPrintf(LevelQuiet, "WARNING: the class list has %d students but the reports account for %d\n", reconciliation.ClassList, reconciliation.Accounted())
+		r.logPrintln(LevelQuiet, "**********")
+	}
+	
+	// Reports for each run go in their own folder, to keep them apart from the anonymised scripts
+	// TODO - have the timestamp as a column in the csv. Make this just append details to csv file if it exists
+	report_time := time.Now().Format("2006-01-02-15-04-05")
+	reportDir := outputDir+"/reports/"+report_time
+	err = os.MkdirAll(reportDir, os.ModePerm)
+	if err != nil {
+		return IngestResult{}, err
+	}
+	
+	// Every report is written even if one of them fails, so that as much as possible is kept of a run
+	// where files have already been moved; the first error is returned once they have all been tried
+	var report_err error
+	write_report := func(name string, rows interface{}) {
+		if err := r.writeReport(reportDir, name, rows); err != nil {
+			r.logPrintln(LevelQuiet, "ERROR: could not write the", name, "report:", err)
+			if report_err == nil {
+				report_err = err
+			}
+		}
+	}
+	
+	// The success report includes the checksum and number of pages of each output file
+	with_details := func(subs []parselearn.Submission) []SuccessfulSubmission {
+		var successes []SuccessfulSubmission
+		for _, sub := range subs {
+			success := output_details[normaliseUUN(sub.UUN)]
+			success.Submission = sub
+			success.SourceFile = sub.Filename
+			successes = append(successes, success)
+		}
+		return successes
+	}
+	successes := with_details(submissions)
+	write_report("learn-success", &successes)
+	
+	// Manual submissions are reported on their own, since they have no Learn timestamp and need a closer look
+	if len(manual_submissions) > 0 {
+		manuals := with_details(manual_submissions)
+		write_report("learn-manual", &manuals)
+	}
+	write_report("learn-errors", &bad_submissions)
+	write_report("learn-empty", &empty_submissions)
+	if len(wrong_format_submissions) > 0 {
+		write_report("learn-wrongformat", &wrong_format_submissions)
+	}
+	if len(too_many_files) > 0 {
+		write_report("learn-toomanyfiles", &too_many_files)
+	}
+	write_report("learn-nosubmission", &no_submissions)
+	if len(already_done) > 0 {
+		write_report("learn-alreadydone", &already_done)
+	}
+	if len(still_being_written) > 0 {
+		write_report("learn-stillbeingwritten", &still_being_written)
+	}
+	if len(rejected_submissions) > 0 {
+		write_report("learn-rejected", &rejected_submissions)
+	}
+	if len(conflicts) > 0 {
+		write_report("learn-conflicts", &conflicts)
+	}
+	if len(unknown_students) > 0 {
+		write_report("learn-unknownstudent", &unknown_students)
+	}
+	if len(uun_mismatches) > 0 {
+		write_report("learn-uunmismatch", &uun_mismatches)
+	}
+	if len(duplicates) > 0 {
+		write_report("learn-duplicates", &duplicates)
+	}
+
+	// Write the students who were left out for having no exam number
+	if len(missing_examno) > 0 {
+		write_report("learn-missingexamno", &missing_examno)
+	}
+
+	// Write the students who were left out because they have withdrawn
+	if len(withdrawn) > 0 {
+		write_report("learn-withdrawn", &withdrawn)
+	}
+
+	// Write the manifest linking each output file to its source
+	write_report("manifest", &manifest)
+
+	// Write the key that de-anonymises the marked scripts, sorted by exam number to match the output files.
+	// Students with a conflict are included, since their existing output file is still marked
+	exam_key := []ExamNumberKey{}
+	for _, sub := range append(append(submissions, manual_submissions...), conflicts...) {
+		exam_key = append(exam_key, ExamNumberKey{ExamNumber: sub.ExamNumber, UUN: sub.UUN})
+	}
+	sort.Slice(exam_key, func(i, j int) bool { return exam_key[i].ExamNumber < exam_key[j].ExamNumber })
+	write_report("examno_to_uun", &exam_key)
+
+	// Write the reconciliation figures
+	write_report("learn-reconciliation", &[]Reconciliation{reconciliation})
+
+	// Write the submission summary
+	write_report("learn-submissionsummary", &submission_summaries)
+	
+	// Write the histogram of submission times
+	if !no_deadline {
+		submission_time_buckets := submission_times.buckets()
+		write_report("learn-submissiontimes", &submission_time_buckets)
+	}
+	
+	// Write the JSON summary if needed
+	if opts.JSONReport {
+		summary := JSONReport{
+			Deadline:          "none",
+			FilesProcessed:    r.processedFiles,
+			BytesProcessed:    r.processedBytes,
+			Reconciliation:    reconciliation,
+			ExtraTimeUsage:    extra_time_usage,
+			Submissions:       submission_summaries,
+		}
+		if !no_deadline {
+			summary.Deadline = deadline_time.Format(time.RFC3339)
+		}
+		json_file, err := os.OpenFile(reportDir+"/learn-summary.json", os.O_RDWR|os.O_CREATE|os.O_TRUNC, r.outputMode)
+		if err == nil {
+			encoder := json.NewEncoder(json_file)
+			encoder.SetIndent("", "\t")
+			err = encoder.Encode(summary)
+			if close_err := json_file.Close(); err == nil {
+				err = close_err
+			}
+		}
+		if err != nil {
+			r.logPrintln(LevelQuiet, "ERROR: could not write the JSON summary:", err)
+			if report_err == nil {
+				report_err = err
+			}
+		}
+	}
+	if report_err != nil {
+		return IngestResult{}, report_err
+	}
+	
+	// Anything still in the input folders is a submission that needs sorting out by hand
+	var leftovers []string
+	if opts.RequireClean {
+		leftovers = leftoverFiles(input_dirs)
+		if len(leftovers) > 0 {
+			r.logPrintln(LevelQuiet, "\n\n**********")
+			r.logPrintln(LevelQuiet, "ERROR:", len(leftovers), "files are left to be dealt with:")
+			for _, leftover := range leftovers {
+				r.logPrintln(LevelQuiet, " - ", leftover)
+			}
+			r.logPrintln(LevelQuiet, "**********")
+		}
+	}
+	
+	return IngestResult{
+		Submissions:       submissions,
+		ManualSubmissions: manual_submissions,
+		BadSubmissions:    bad_submissions,
+		EmptySubmissions:  empty_submissions,
+		WrongFormat:       wrong_format_submissions,
+		NoSubmissions:     no_submissions,
+		AlreadyDone:       already_done,
+		StillBeingWritten: still_being_written,
+		Conflicts:         conflicts,
+		Rejected:          rejected_submissions,
+		TooManyFiles:      too_many_files,
+		MissingExamNumber: missing_examno,
+		Withdrawn:         withdrawn,
+		UnknownStudents:   unknown_students,
+		UUNMismatches:     uun_mismatches,
+		MissingExtraTime:  missing_extra_time,
+		Duplicates:        duplicates,
+		Leftovers:         leftovers,
+		Reconciliation:    reconciliation,
+		ExtraTimeUsage:    extra_time_usage,
+		SubmissionTimes:   submission_times,
+		FilesProcessed:    r.processedFiles,
+		BytesProcessed:    r.processedBytes,
+		ReportDir:         reportDir,
+		NeedsAttention:    len(bad_submissions) > 0 || len(wrong_format_submissions) > 0 || len(missing_examno) > 0 || len(conflicts) > 0 || len(unknown_students) > 0 || len(uun_mismatches) > 0 || len(duplicates) > 0 || len(leftovers) > 0 || len(still_being_written) > 0 || !reconciliation.Balanced() || (opts.Strict && len(no_submissions)+len(empty_submissions) > 0),
+	}, nil
+}
+
+// The settings for one call of Ingest that are needed when moving files and writing reports,
+// and the running totals of what was moved, so that runs don't share anything
+type run struct {
+	// Where the progress of the run is printed, as much as its logging level asks for
+	logger
+
+	// The time zone that deadlines and the submission times on Learn receipts are given in
+	timeZone *time.Location
+
+	// When set, files are copied rather than moved, and nothing is ever removed from the input folders
+	copyOnly bool
+
+	// When set, output files are hard links to the submitted files where possible. By default they
+	// are full copies, so they share nothing (such as the inode and its metadata) with the submitted file
+	hardLink bool
+
+	// When set, an existing output file is never replaced, and the clash is reported instead
+	noOverwrite bool
+
+	// When set, the document information (such as Author) is removed from output PDFs
+	stripMetadata bool
+
+	// The permissions given to output files and reports, which hold personal data and so
+	// shouldn't be readable by everyone on a shared filesystem
+	outputMode os.FileMode
+
+	// When set, output files keep the modification time of the file they were copied from
+	preserveMtime bool
+
+	// The form the reports are written in: csv, tsv or json
+	reportFormat string
+
+	// The audit log of every operation on the students' files, if one was asked for
+	audit *auditLog
+
+	// Whether the students' files have started to be dealt with, after which an error is no longer a SetupError
+	started bool
+
+	// The number and total size of the files moved into place by moveFile in this run
+	processedFiles int
+	processedBytes int64
+}
+
+// What moveFile returns when noOverwrite stops it replacing an existing output file
+const outputConflict = "Conflict - existing output kept"
+
+// Move the path_from file to path_to, but only if there is not already a file at path_to
+// that is at least as new. So:
+//  - no file at path_to: path_from is moved there ("File created")
+//  - path_to is older than path_from: it is replaced ("File replaced")
+//  - path_to is newer than path_from, or has the same modification time: path_to is kept and
+//    path_from is removed ("File already exists")
+// With noOverwrite, path_to is always kept whatever its age: path_from is removed if it has the same
+// contents ("File already exists"), and otherwise left where it is for someone to decide which one
+// should be marked (outputConflict).
+// An error is returned if path_from could not be read or copied, in which case it is never removed.
+func (r *run) moveFile(path_from string, path_to string) (string, error) {
+
+	// Check path_from exists, and its age
+	var file_from os.FileInfo
+	err := retry(r.logger, func() (err error) {
+		file_from, err = os.Stat(path_from)
+		return
+	})
+	if err != nil {
+		r.audit.record("move", path_from, path_to, "Could not read: "+err.Error())
+		return "", fmt.Errorf("could not read %s: %v", path_from, err)
+	}
+    time_from := file_from.ModTime()
+	
+	// If there is a file at path_to, check its age. If it is at least as new as the path_from file, then don't bother copying
+	file_to_exists := false
+    if file_to, err := os.Stat(path_to); err == nil {
+		file_to_exists = true
+		if r.noOverwrite && !sameContents(path_from, path_to) {
+			// Leave both files where they are, so that someone can decide which one should be marked
+			r.audit.record("move", path_from, path_to, outputConflict)
+			return outputConflict, nil
+		}
+		time_to := file_to.ModTime()
+		if(r.noOverwrite || !time_from.After(time_to)) {
+			// No need to copy over, but delete the path_from file since it is not needed
+			r.audit.record("move", path_from, path_to, "File already exists")
+			r.removeFile(path_from)
+			return "File already exists", nil
+		}
+    }
+	
+	// Now copy the path_from file into the path_to location
+	err = retry(r.logger, func() error { return r.copyFile(path_from, path_to) })
+	if err != nil {
+		r.logPrintf(LevelQuiet, "ERROR: could not copy %s, so it has been left in place: %v\n", path_from, err)
+		r.audit.record("move", path_from, path_to, "CopyFile failed: "+err.Error())
+		return "", fmt.Errorf("could not copy %s: %v", path_from, err)
+	}
+	// Make sure the copy really is in place before the original is removed
+	if err = verifyCopy(file_from, path_to); err != nil {
+		r.logPrintf(LevelQuiet, "ERROR: the copy of %s could not be verified, so it has been left in place: %v\n", path_from, err)
+		r.audit.record("move", path_from, path_to, "Copy not verified: "+err.Error())
+		return "", fmt.Errorf("copy of %s not verified: %v", path_from, err)
+	}
+	
+	status := "File created"
+	if(file_to_exists) {
+		status = "File replaced"
+	}
+	r.audit.record("move", path_from, path_to, status)
+	r.processedFiles++
+	r.processedBytes += file_from.Size()
+	// Take out anything in the PDF itself that could identify the student, if asked
+	if r.stripMetadata && hasPDFExtension(path_to) {
+		if err := stripPDFMetadata(path_to, r.outputMode); err != nil {
+			r.logPrintln(LevelQuiet, "WARNING: could not remove the metadata from", path_to, ":", err)
+		} else {
+			r.setCopyTime(path_from, path_to)
+		}
+	}
+	// Get rid of the path_from file, it's no longer needed
+	r.removeFile(path_from)
+	return status, nil
+}
+
+// Check that a copied file is at path_to and is the same size as the original
+func verifyCopy(original os.FileInfo, path_to string) error {
+	copied, err := os.Stat(path_to)
+	if err != nil {
+		return err
+	}
+	if !copied.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path_to)
+	}
+	if copied.Size() != original.Size() {
+		return fmt.Errorf("%s has %d bytes but the original has %d", path_to, copied.Size(), original.Size())
+	}
+	return nil
+}
+
+// Copy a file chosen by hand to path_to, replacing whatever is there whatever its age (and
+// even with noOverwrite), since it was picked over anything else. path_from is never removed,
+// so the same overrides csv can be given on every run.
+func (r *run) copyOverride(path_from string, path_to string) (string, error) {
+	var file_from os.FileInfo
+	err := retry(r.logger, func() (err error) {
+		file_from, err = os.Stat(path_from)
+		return
+	})
+	if err != nil {
+		r.audit.record("override", path_from, path_to, "Could not read: "+err.Error())
+		return "", fmt.Errorf("could not read %s: %v", path_from, err)
+	}
+	status := "File created"
+	if _, err := os.Stat(path_to); err == nil {
+		status = "File replaced"
+	}
+	err = retry(r.logger, func() error { return r.copyFile(path_from, path_to) })
+	if err == nil {
+		err = verifyCopy(file_from, path_to)
+	}
+	if err != nil {
+		r.audit.record("override", path_from, path_to, "Copy failed: "+err.Error())
+		return "", fmt.Errorf("could not copy %s: %v", path_from, err)
+	}
+	r.audit.record("override", path_from, path_to, status)
+	r.processedFiles++
+	r.processedBytes += file_from.Size()
+	if r.stripMetadata && hasPDFExtension(path_to) {
+		if err := stripPDFMetadata(path_to, r.outputMode); err != nil {
+			r.logPrintln(LevelQuiet, "WARNING: could not remove the metadata from", path_to, ":", err)
+		} else {
+			r.setCopyTime(path_from, path_to)
+		}
+	}
+	return status, nil
+}
+
+// The checksum of an output file, or blank (with a warning) if it can't be read
+func (r *run) outputChecksum(path string) string {
+	sum, err := fileChecksum(path)
+	if err != nil {
+		r.logPrintln(LevelQuiet, "WARNING: could not compute the checksum of", path, ":", err)
+		return ""
+	}
+	return sum
+}
+
+// Where a successful submission came from, for the success report
+const (
+	sourceLearn    = "Learn"
+	sourceTurnitin = "Turnitin" // listed in the index csv of a Turnitin export
+	sourceForms    = "Forms"    // uploaded to MS Forms, and listed in the forms csv
+	sourceManual   = "Manual"   // a uun.pdf put in learndir by hand
+	sourceOverride = "Override" // given for the student in the -overrides csv
+	sourceExisting = "Existing" // already in outputdir, with -reportsonly
+)
+
+// The source, checksum and number of pages of an output file, for the success report;
+// the number of pages is left blank if it can't be worked out
+func (r *run) describeOutput(path string, source string) (details SuccessfulSubmission) {
+	details.Source = source
+	details.OutputPath = path
+	details.Checksum = r.outputChecksum(path)
+	defer func() {
+		// The PDF library can panic on badly broken files, which shouldn't stop the run
+		if p := recover(); p != nil {
+			r.logPrintln(LevelVerbose, "could not count the pages in", path, ":", p)
+		}
+	}()
+	if pages, err := countPages(path); err == nil {
+		details.Pages = strconv.Itoa(pages)
+	} else {
+		r.logPrintln(LevelVerbose, "could not count the pages in", path, ":", err)
+	}
+	return details
+}
+
+// What LateSubmission is set to for a submission made after the -hardcutoff, which is never marked
+const beyondCutoff = "Rejected - beyond cutoff"
+
+// What LateSubmission is set to when the date a submission was made can't be read
+const unknownSubmissionTime = "Unknown"
+
+// What LateSubmission is set to when there is no deadline, so lateness is not considered
+const lateNotEvaluated = "Not evaluated"
+
+// The date used as a starting point when looking for a student's most recent submission
+const dummyDateSubmitted = "2000-01-01-12-00-00"
+
+// Work out how many students would have an on-time submission if the normal deadline were candidate,
+// leaving the deadlines of students with their own in the class list as they are. Submission times
+// are read in the time zone loc
+func simulateDeadline(classlist map[string]Students, learn_files map[string][]parselearn.Submission, candidate time.Time, loc *time.Location) DeadlineSimulation {
+	simulation := DeadlineSimulation{Deadline: candidate.Format("2006-01-02 15:04:05")}
+	for uun, student := range classlist {
+		student_deadline, extratime := student.deadlineAndExtraTime(candidate)
+		on_time, late := false, false
+		for _, sub := range learn_files[uun] {
+			sub_time, err := time.ParseInLocation("2006-01-02-15-04-05", sub.DateSubmitted, loc)
+			if err != nil {
+				continue
+			}
+			if isLate(sub_time, student_deadline, extratime) {
+				late = true
+			} else {
+				on_time = true
+			}
+		}
+		switch {
+		case on_time:
+			simulation.OnTime++
+		case late:
+			simulation.Late++
+		case len(learn_files[uun]) > 0:
+			simulation.Unknown++
+		default:
+			simulation.NoSubmission++
+		}
+	}
+	return simulation
+}
+
+// Make the summary report entry for a submission, adding a timestamp that spreadsheets can read
+// and, for late submissions, how many minutes after the student's own deadline it arrived (the
+// submission time being in the time zone loc)
+func newSubmissionSummary(sub parselearn.Submission, student_deadline time.Time, loc *time.Location) SubmissionSummary {
+	summary := SubmissionSummary{Submission: sub, Attempt: learnAttempt(sub.ReceiptFilename)}
+	sub_time, err := time.ParseInLocation("2006-01-02-15-04-05", sub.DateSubmitted, loc)
+	if err == nil && sub.DateSubmitted != dummyDateSubmitted {
+		summary.SubmittedAt = sub_time.Format("2006-01-02T15:04:05")
+		if sub.LateSubmission == "LATE" {
+			summary.MinutesLate = int(sub_time.Sub(student_deadline).Round(time.Minute).Minutes())
+		}
+	}
+	return summary
+}
+
+// Merge the PDFs in a submission with several files into one temporary PDF, returning
+// its path and the paths of the files that went into it
+func mergeSubmission(submission_dir string, submission parselearn.Submission) (string, []string, error) {
+	filenames, err := receiptFilenames(submission_dir+"/"+submission.ReceiptFilename)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(filenames) != submission.NumberOfFiles {
+		return "", nil, fmt.Errorf("receipt lists %d files, expected %d", len(filenames), submission.NumberOfFiles)
+	}
+	var parts []string
+	for _, filename := range filenames {
+		if err := checkPDF(submission_dir+"/"+filename); err != nil {
+			return "", nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		parts = append(parts, submission_dir+"/"+filename)
+	}
+	merged, err := os.CreateTemp("", "gradex-merged-*.pdf")
+	if err != nil {
+		return "", nil, err
+	}
+	merged.Close()
+	if err := mergePDFs(parts, merged.Name()); err != nil {
+		os.Remove(merged.Name())
+		return "", nil, err
+	}
+	return merged.Name(), parts, nil
+}
+
+// Make the manifest entry for a submission that was placed at output_path
+func newManifestEntry(sub parselearn.Submission, output_path string) ManifestEntry {
+	return ManifestEntry{
+		ExamNumber:      sub.ExamNumber,
+		UUN:             sub.UUN,
+		Filename:        sub.Filename,
+		ReceiptFilename: sub.ReceiptFilename,
+		OutputPath:      output_path,
+		OutputFile:      sub.OutputFile,
+	}
+}
+
+// Build the name of a student's output file from the filename template
+func outputFilename(tmpl *template.Template, name OutputName) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, name); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Add the late prefix and suffix to a file name, keeping the suffix before the extension
+func lateFilename(name string, prefix string, suffix string) string {
+	ext := filepath.Ext(name)
+	return prefix+strings.TrimSuffix(name, ext)+suffix+ext
+}
+
+func (r *run) removeFile(path string) {
+	if r.copyOnly {
+		return
+	}
+	err := retry(r.logger, func() error { return os.Remove(path) })
+	if os.IsNotExist(err) {
+		// Nothing to do - this can happen when the Learn receipts have been kept from an earlier run
+		return
+	}
+	if err != nil {
+		r.audit.record("remove", path, "", err.Error())
+		r.logPrintln(LevelQuiet, "WARNING: could not remove", path, ":", err)
+		return
+	}
+	r.audit.record("remove", path, "", "Removed")
+}
+
+
+
+// File copy functions - https://stackoverflow.com/a/21067803
+
+// copyFile copies a file from src to dst. If src and dst files exist, and are
+// the same, then return success. Otherise, attempt to create a hard link
+// between the two files (only if hardLink is set). If that fail, copy the
+// file contents from src to dst.
+func (r *run) copyFile(src, dst string) (err error) {
+    sfi, err := os.Stat(src)
+    if err != nil {
+        return
+    }
+    if !sfi.Mode().IsRegular() {
+        // cannot copy non-regular files (e.g., directories,
+        // symlinks, devices, etc.)
+        return fmt.Errorf("CopyFile: non-regular source file %s (%q)", sfi.Name(), sfi.Mode().String())
+    }
+    dfi, err := os.Stat(dst)
+    if err != nil {
+        if !os.IsNotExist(err) {
+            return
+        }
+    } else {
+        if !(dfi.Mode().IsRegular()) {
+            return fmt.Errorf("CopyFile: non-regular destination file %s (%q)", dfi.Name(), dfi.Mode().String())
+        }
+        if os.SameFile(sfi, dfi) {
+            return
+        }
+    }
+    if r.hardLink {
+        if err = os.Link(src, dst); err == nil {
+            return
+        }
+    }
+    err = r.copyFileContents(src, dst)
+    return
+}
+
+// copyFileContents copies the contents of the file named src to the file named
+// by dst. The file will be created if it does not already exist. If the
+// destination file exists, all it's contents will be replaced by the contents
+// of the source file. The contents are first written to a temporary file next
+// to dst, which is then renamed into place, so that dst is never left holding
+// a partial copy if the program is stopped part way through.
+func (r *run) copyFileContents(src, dst string) (err error) {
+    in, err := os.Open(src)
+    if err != nil {
+        return
+    }
+    defer in.Close()
+    out, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-")
+    if err != nil {
+        return
+    }
+    tmp := out.Name()
+    if _, err = io.Copy(out, in); err != nil {
+        out.Close()
+        os.Remove(tmp)
+        return
+    }
+    if err = out.Sync(); err != nil {
+        out.Close()
+        os.Remove(tmp)
+        return
+    }
+    if err = out.Close(); err != nil {
+        os.Remove(tmp)
+        return
+    }
+    r.setCopyTime(src, tmp)
+    os.Chmod(tmp, r.outputMode)
+    if err = os.Rename(tmp, dst); err != nil {
+        // The rename failed, so fall back to copying straight into dst
+        os.Remove(tmp)
+        err = r.copyFileContentsDirect(src, dst)
+    }
+    return
+}
+
+// copyFileContentsDirect copies the contents of the file named src straight
+// into the file named by dst, creating or replacing it.
+func (r *run) copyFileContentsDirect(src, dst string) (err error) {
+    in, err := os.Open(src)
+    if err != nil {
+        return
+    }
+    defer in.Close()
+    out, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_TRUNC, r.outputMode)
+    if err != nil {
+        return
+    }
+    defer func() {
+        cerr := out.Close()
+        if err == nil {
+            err = cerr
+        }
+        r.setCopyTime(src, dst)
+    }()
+    if _, err = io.Copy(out, in); err != nil {
+        return
+    }
+    err = out.Sync()
+    return
+}
+
+// Update the "last modified" time on a newly created file, either to now or to match the original
+func (r *run) setCopyTime(src, dst string) {
+	newtime := time.Now().Local()
+	if r.preserveMtime {
+		if sfi, serr := os.Stat(src); serr == nil {
+			newtime = sfi.ModTime()
+		}
+	}
+	err := os.Chtimes(dst, newtime, newtime)
+	if err != nil {
+		r.logPrintln(LevelQuiet, err)
+	}
+}
+
+func PrettyPrintStruct(layout interface{}) error {
+
+	json, err := json.MarshalIndent(layout, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(json))
+	return nil
+}
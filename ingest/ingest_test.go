@@ -0,0 +1,465 @@
+package ingest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Make a Learn receipt for uun, submitted at the given time (as Learn writes it, e.g. 2020-04-22 15:51:42),
+// and the PDF it lists, in dir. Returns the paths of the receipt and the PDF
+func writeSubmission(t *testing.T, dir string, uun string, submitted string) (string, string) {
+	t.Helper()
+	attempt := strings.NewReplacer(" ", "-", ":", "-").Replace(submitted)
+	receipt := filepath.Join(dir, "Exam_"+uun+"_attempt_"+attempt+".txt")
+	pdf := filepath.Join(dir, "Exam_"+uun+"_attempt_"+attempt+"_answers.pdf")
+
+	contents := fmt.Sprintf(`Name: Ann Other (%s)
+Assignment: Exam
+Date Submitted: %s
+Current Grade: Needs Grading
+
+Submission Field:
+There is no student submission text data for this assignment.
+
+Comments:
+There are no student comments for this assignment.
+
+Files:
+	Original filename: answers.pdf
+	Filename: %s
+`, uun, submitted, filepath.Base(pdf))
+	if err := os.WriteFile(receipt, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Each PDF is different, so that none of them are reported as duplicates
+	if err := writeCoverSheet(pdf, "Answers from "+uun, []string{"Submitted " + submitted}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return receipt, pdf
+}
+
+// Write a class list csv into dir, with a row for each of the students (as UUN,Exam Number,Extra Time)
+func writeClassList(t *testing.T, dir string, students ...string) string {
+	t.Helper()
+	path := filepath.Join(dir, "classlist.csv")
+	contents := "UUN,Exam Number,Extra Time\n" + strings.Join(students, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// The options a run would have from the command line defaults, reading from learnDir
+func testOptions(t *testing.T, learnDir string, classList string) IngestOptions {
+	t.Helper()
+	if _, err := time.LoadLocation("Europe/London"); err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	return IngestOptions{
+		Course:           "MATH00000",
+		ClassListCSV:     classList,
+		ClassListColumns: ClassListColumns{UUN: "UUN", ExamNumber: "Exam Number", ExtraTime: "Extra Time", Deadline: "Deadline", Status: "Status", Group: "Group"},
+		LearnDir:         learnDir,
+		OutputDir:        filepath.Join(t.TempDir(), "output"),
+		Deadline:         "2020-04-22-16-00",
+		TimeZone:         "Europe/London",
+		GracePeriod:      59 * time.Second,
+		FilenameTemplate: "{{.Course}}_{{.ExamNumber}}.pdf",
+		LatePrefix:       "LATE-",
+		Workers:          1,
+		MaxMergeFiles:    10,
+	}
+}
+
+// The names of the files in dir, sorted
+func fileNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The rows of one of the reports written by a run
+func reportRows(t *testing.T, result IngestResult, name string) []map[string]string {
+	t.Helper()
+	rows, err := readReport(filepath.Join(result.ReportDir, name+".csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func assertNames(t *testing.T, what string, got []string, want ...string) {
+	t.Helper()
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("%s: got %q, want %q", what, got, want)
+	}
+}
+
+func readFile(t *testing.T, path string) []byte {
+	t.Helper()
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contents
+}
+
+func TestIngest(t *testing.T) {
+	learnDir := t.TempDir()
+	classList := writeClassList(t, t.TempDir(),
+		"s1234567,B123456,",
+		"s7654321,B654321,15",
+		"s2222222,B222222,")
+	superseded_receipt, superseded_pdf := writeSubmission(t, learnDir, "s1234567", "2020-04-22 14:00:00")
+	used_receipt, used_pdf := writeSubmission(t, learnDir, "s1234567", "2020-04-22 15:51:42")
+	extra_time_receipt, extra_time_pdf := writeSubmission(t, learnDir, "s7654321", "2020-04-22 16:10:00")
+	unknown_receipt, unknown_pdf := writeSubmission(t, learnDir, "s9999999", "2020-04-22 15:00:00")
+	used_contents := readFile(t, used_pdf)
+	extra_time_contents := readFile(t, extra_time_pdf)
+
+	opts := testOptions(t, learnDir, classList)
+	result, err := Ingest(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The submission used for each student is in the output folder under their exam number
+	assertNames(t, "output folder", fileNames(t, opts.OutputDir), "MATH00000_B123456.pdf", "MATH00000_B654321.pdf", "reports")
+	if !bytes.Equal(readFile(t, filepath.Join(opts.OutputDir, "MATH00000_B123456.pdf")), used_contents) {
+		t.Error("MATH00000_B123456.pdf is not the later of s1234567's submissions")
+	}
+	if !bytes.Equal(readFile(t, filepath.Join(opts.OutputDir, "MATH00000_B654321.pdf")), extra_time_contents) {
+		t.Error("MATH00000_B654321.pdf is not s7654321's submission")
+	}
+
+	if len(result.Submissions) != 2 || len(result.NoSubmissions) != 1 || len(result.UnknownStudents) != 1 {
+		t.Errorf("got %d successful, %d with no submission and %d unknown, want 2, 1 and 1",
+			len(result.Submissions), len(result.NoSubmissions), len(result.UnknownStudents))
+	}
+	if !result.NeedsAttention {
+		t.Error("a run with an unknown student should need attention")
+	}
+
+	// The reports say the same
+	var successes []string
+	for _, row := range reportRows(t, result, "learn-success") {
+		successes = append(successes, row["UUN"]+" "+row["ExamNumber"]+" "+row["OutputFile"]+" "+row["LateSubmission"])
+	}
+	assertNames(t, "learn-success", successes, "S1234567 B123456 File created ", "S7654321 B654321 File created ")
+	var none []string
+	for _, row := range reportRows(t, result, "learn-nosubmission") {
+		none = append(none, row["UUN"])
+	}
+	assertNames(t, "learn-nosubmission", none, "S2222222") // every report has UUNs in the same, normalised, form
+	var key []string
+	for _, row := range reportRows(t, result, "examno_to_uun") {
+		key = append(key, row["ExamNumber"]+" "+row["UUN"])
+	}
+	assertNames(t, "examno_to_uun", key, "B123456 S1234567", "B654321 S7654321")
+
+	// The files used and the superseded one have gone from the Learn folder, and the unknown student's are left
+	for _, path := range []string{superseded_receipt, superseded_pdf, used_receipt, used_pdf, extra_time_receipt, extra_time_pdf} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s should have been removed from the Learn folder", filepath.Base(path))
+		}
+	}
+	assertNames(t, "Learn folder", fileNames(t, learnDir), filepath.Base(unknown_receipt), filepath.Base(unknown_pdf))
+}
+
+func TestIngestLate(t *testing.T) {
+	for _, keepLate := range []bool{false, true} {
+		t.Run(fmt.Sprintf("keeplate=%t", keepLate), func(t *testing.T) {
+			learnDir := t.TempDir()
+			classList := writeClassList(t, t.TempDir(), "s1234567,B123456,")
+			_, on_time_pdf := writeSubmission(t, learnDir, "s1234567", "2020-04-22 15:00:00")
+			writeSubmission(t, learnDir, "s1234567", "2020-04-22 16:30:00")
+			on_time_contents := readFile(t, on_time_pdf)
+
+			opts := testOptions(t, learnDir, classList)
+			opts.KeepLate = keepLate
+			result, err := Ingest(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The on-time submission is used, and the late one is deleted unless it is kept
+			if !bytes.Equal(readFile(t, filepath.Join(opts.OutputDir, "MATH00000_B123456.pdf")), on_time_contents) {
+				t.Error("MATH00000_B123456.pdf is not the on-time submission")
+			}
+			if len(result.Submissions) != 1 {
+				t.Errorf("got %d successful submissions, want 1", len(result.Submissions))
+			}
+			assertNames(t, "Learn folder", fileNames(t, learnDir))
+			if keepLate {
+				assertNames(t, "late folder", fileNames(t, filepath.Join(opts.OutputDir, "late")),
+					"MATH00000_B123456_2020-04-22-16-30-00.pdf", "MATH00000_B123456_2020-04-22-16-30-00.txt")
+			} else if _, err := os.Stat(filepath.Join(opts.OutputDir, "late")); !os.IsNotExist(err) {
+				t.Error("there should be no late folder without -keeplate")
+			}
+		})
+	}
+}
+
+func TestIngestCopyOnly(t *testing.T) {
+	learnDir := t.TempDir()
+	classList := writeClassList(t, t.TempDir(), "s1234567,B123456,")
+	writeSubmission(t, learnDir, "s1234567", "2020-04-22 14:00:00")
+	writeSubmission(t, learnDir, "s1234567", "2020-04-22 15:51:42")
+	writeSubmission(t, learnDir, "s1234567", "2020-04-22 16:30:00")
+	before := fileNames(t, learnDir)
+
+	opts := testOptions(t, learnDir, classList)
+	opts.CopyOnly = true
+	if _, err := Ingest(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	assertNames(t, "output folder", fileNames(t, opts.OutputDir), "MATH00000_B123456.pdf", "reports")
+	assertNames(t, "Learn folder", fileNames(t, learnDir), before...)
+}
+
+func TestIngestSetupError(t *testing.T) {
+	opts := testOptions(t, t.TempDir(), filepath.Join(t.TempDir(), "missing.csv"))
+	_, err := Ingest(opts)
+	var setup_error *SetupError
+	if !errors.As(err, &setup_error) {
+		t.Errorf("got %v, want a SetupError for the missing class list", err)
+	}
+}
+
+func TestIngestLeadingZeros(t *testing.T) {
+	learnDir := t.TempDir()
+	classList := writeClassList(t, t.TempDir(), "s1234567,0042,")
+	writeSubmission(t, learnDir, "s1234567", "2020-04-22 15:51:42")
+
+	opts := testOptions(t, learnDir, classList)
+	result, err := Ingest(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The exam number is kept as the text in the class list, zeros and all
+	assertNames(t, "output folder", fileNames(t, opts.OutputDir), "MATH00000_0042.pdf", "reports")
+	var successes []string
+	for _, row := range reportRows(t, result, "learn-success") {
+		successes = append(successes, row["ExamNumber"]+" "+row["OutputPath"])
+	}
+	assertNames(t, "learn-success", successes, "0042 "+filepath.Join(opts.OutputDir, "MATH00000_0042.pdf"))
+	var key []string
+	for _, row := range reportRows(t, result, "examno_to_uun") {
+		key = append(key, row["ExamNumber"]+" "+row["UUN"])
+	}
+	assertNames(t, "examno_to_uun", key, "0042 S1234567")
+}
+
+func TestIngestExamNumberClash(t *testing.T) {
+	tests := []struct {
+		name   string
+		examno string // the second student's exam number, the first's being 0042
+	}{
+		{"same apart from leading zeros", "42"},
+		{"same exam number", "0042"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			learnDir := t.TempDir()
+			classList := writeClassList(t, t.TempDir(), "s1234567,0042,", "s7654321,"+test.examno+",")
+			receipt, pdf := writeSubmission(t, learnDir, "s1234567", "2020-04-22 15:51:42")
+
+			// The two students could end up sharing an output file, so nothing is done
+			opts := testOptions(t, learnDir, classList)
+			_, err := Ingest(opts)
+			var setup_error *SetupError
+			if !errors.As(err, &setup_error) {
+				t.Errorf("got %v, want a SetupError for the clashing exam numbers", err)
+			}
+			assertNames(t, "Learn folder", fileNames(t, learnDir), filepath.Base(receipt), filepath.Base(pdf))
+			if _, err := os.Stat(filepath.Join(opts.OutputDir, "MATH00000_0042.pdf")); !os.IsNotExist(err) {
+				t.Error("no output file should be made when exam numbers clash")
+			}
+		})
+	}
+}
+
+func TestMoveFile(t *testing.T) {
+	submitted := time.Date(2020, 4, 22, 15, 51, 42, 0, time.UTC)
+	tests := []struct {
+		name        string
+		existing    string        // the contents of the output file already there, if there is one
+		age         time.Duration // its modification time relative to the submission's
+		noOverwrite bool
+		status      string
+		replaced    bool // whether the output file ends up with the submission in it
+		removed     bool // whether the submission is gone from where it was
+	}{
+		{"no output file", "", 0, false, "File created", true, true},
+		{"older output file", "%PDF-existing", -time.Hour, false, "File replaced", true, true},
+		{"newer output file", "%PDF-existing", time.Hour, false, "File already exists", false, true},
+		{"output file of the same age", "%PDF-existing", 0, false, "File already exists", false, true},
+		{"older output file with nooverwrite", "%PDF-existing", -time.Hour, true, outputConflict, false, false},
+		{"newer output file with nooverwrite", "%PDF-existing", time.Hour, true, outputConflict, false, false},
+		{"same output file with nooverwrite", "%PDF-submission", -time.Hour, true, "File already exists", true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			from := filepath.Join(dir, "submission.pdf")
+			to := filepath.Join(dir, "output.pdf")
+			if err := os.WriteFile(from, []byte("%PDF-submission"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(from, submitted, submitted); err != nil {
+				t.Fatal(err)
+			}
+			if test.existing != "" {
+				if err := os.WriteFile(to, []byte(test.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+				existing := submitted.Add(test.age)
+				if err := os.Chtimes(to, existing, existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r := &run{timeZone: time.Local, outputMode: 0640, reportFormat: "csv", noOverwrite: test.noOverwrite}
+			status, err := r.moveFile(from, to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != test.status {
+				t.Errorf("got status %q, want %q", status, test.status)
+			}
+			if replaced := string(readFile(t, to)) == "%PDF-submission"; replaced != test.replaced {
+				t.Errorf("output file replaced: got %t, want %t", replaced, test.replaced)
+			}
+			if _, err := os.Stat(from); os.IsNotExist(err) != test.removed {
+				t.Errorf("submission removed: got %t, want %t", os.IsNotExist(err), test.removed)
+			}
+		})
+	}
+}
+
+func TestMoveFileFails(t *testing.T) {
+	dir := t.TempDir()
+	from := filepath.Join(dir, "submission.pdf")
+	if err := os.WriteFile(from, []byte("%PDF-submission"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		from string
+		to   string
+	}{
+		{"missing submission", filepath.Join(dir, "missing.pdf"), filepath.Join(dir, "output.pdf")},
+		{"missing output folder", from, filepath.Join(dir, "missing", "output.pdf")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &run{timeZone: time.Local, outputMode: 0640, reportFormat: "csv"}
+			if _, err := r.moveFile(test.from, test.to); err == nil {
+				t.Errorf("got no error moving %s to %s", test.from, test.to)
+			}
+			if _, err := os.Stat(from); err != nil {
+				t.Errorf("submission not left in place: %v", err)
+			}
+		})
+	}
+}
+
+func TestIsLate(t *testing.T) {
+	deadline := time.Date(2020, 4, 22, 16, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		submitted time.Time
+		extraTime int
+		late      bool
+	}{
+		{"before the deadline", deadline.Add(-time.Minute), 0, false},
+		{"on the deadline", deadline, 0, false},
+		{"just after the deadline", deadline.Add(time.Second), 0, true},
+		{"after the deadline with extra time", deadline.Add(10 * time.Minute), 15, false},
+		{"at the end of the extra time", deadline.Add(15 * time.Minute), 15, false},
+		{"after the extra time", deadline.Add(15*time.Minute + time.Second), 15, true},
+		{"after the deadline with zero extra time", deadline.Add(time.Second), 0, true},
+		{"negative extra time counts as none", deadline.Add(time.Second), -15, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if late := isLate(test.submitted, deadline, test.extraTime); late != test.late {
+				t.Errorf("isLate(%s, %s, %d) = %t, want %t", test.submitted, deadline, test.extraTime, late, test.late)
+			}
+		})
+	}
+}
+
+// A lowercase UUN in the class list is the same student as an uppercase one in a receipt's file name
+func TestIngestUUNCase(t *testing.T) {
+	learnDir := t.TempDir()
+	classList := writeClassList(t, t.TempDir(), "s1234567,B123456,")
+	receipt, _ := writeSubmission(t, learnDir, "s1234567", "2020-04-22 15:51:42")
+	upper_receipt := filepath.Join(learnDir, strings.Replace(filepath.Base(receipt), "_s1234567_", "_S1234567_", 1))
+	if err := os.Rename(receipt, upper_receipt); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := testOptions(t, learnDir, classList)
+	result, err := Ingest(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Submissions) != 1 || len(result.UnknownStudents) != 0 || len(result.NoSubmissions) != 0 {
+		t.Errorf("got %d successful, %d unknown and %d with no submission, want 1, 0 and 0",
+			len(result.Submissions), len(result.UnknownStudents), len(result.NoSubmissions))
+	}
+	assertNames(t, "output folder", fileNames(t, opts.OutputDir), "MATH00000_B123456.pdf", "reports")
+	assertNames(t, "Learn folder", fileNames(t, learnDir))
+}
+
+// A Turnitin submission is chosen between alongside the Learn ones, and has no receipt to keep
+func TestIngestTurnitin(t *testing.T) {
+	learnDir := t.TempDir()
+	turnitinDir := t.TempDir()
+	classList := writeClassList(t, t.TempDir(), "s1234567,B123456,")
+	writeSubmission(t, learnDir, "s1234567", "2020-04-22 14:00:00")
+	turnitin_pdf := filepath.Join(turnitinDir, "answers.pdf")
+	if err := writeCoverSheet(turnitin_pdf, "Answers from Turnitin", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	index := "Student ID,Paper ID,Date Uploaded,File Name\ns1234567,1001,2020-04-22 15:51:42,answers.pdf\n"
+	if err := os.WriteFile(filepath.Join(turnitinDir, "index.csv"), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+	turnitin_contents := readFile(t, turnitin_pdf)
+
+	opts := testOptions(t, learnDir, classList)
+	opts.TurnitinDir = turnitinDir
+	opts.KeepReceipts = true
+	opts.AnonymiseReceipts = true
+	opts.AuditLog = filepath.Join(t.TempDir(), "audit.csv")
+	if _, err := Ingest(opts); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readFile(t, filepath.Join(opts.OutputDir, "MATH00000_B123456.pdf")), turnitin_contents) {
+		t.Error("MATH00000_B123456.pdf is not the later, Turnitin, submission")
+	}
+	// Only the superseded Learn submission had a receipt to keep
+	assertNames(t, "receipts folder", fileNames(t, filepath.Join(opts.OutputDir, "receipts")), "MATH00000_B123456_2020-04-22-14-00-00.txt")
+	if audit := string(readFile(t, opts.AuditLog)); strings.Contains(audit, turnitinReceiptPrefix) {
+		t.Errorf("the audit log has an operation on a Turnitin receipt, which doesn't exist:\n%s", audit)
+	}
+}
@@ -0,0 +1,57 @@
+package ingest
+
+import (
+	"fmt"
+	"time"
+)
+
+// Levels of console output, chosen with the -q and -v flags
+const (
+	LevelQuiet   = iota // only warnings and errors
+	LevelNormal         // also the settings used and the final counts
+	LevelVerbose        // also the details for every student
+)
+
+// Prints to the console the messages at or below its logging level, so that each run can have its own
+type logger struct {
+	level int
+}
+
+// Print a line to the console if the logging level is at least level
+func (l logger) logPrintln(level int, a ...interface{}) {
+	if l.level >= level {
+		fmt.Println(a...)
+	}
+}
+
+// Print formatted output to the console if the logging level is at least level
+func (l logger) logPrintf(level int, format string, a ...interface{}) {
+	if l.level >= level {
+		fmt.Printf(format, a...)
+	}
+}
+
+// Prints how far through a long loop the run has got, every so many items or seconds,
+// so that it can be seen to be moving on a slow filesystem
+type progressReporter struct {
+	logger
+	what     string
+	total    int
+	count    int
+	every    int
+	interval time.Duration
+	last     time.Time
+}
+
+func newProgressReporter(log logger, what string, total int) *progressReporter {
+	return &progressReporter{logger: log, what: what, total: total, every: 50, interval: 5 * time.Second, last: time.Now()}
+}
+
+// Count the next item, and print the progress if it is time to
+func (p *progressReporter) step() {
+	p.count++
+	if p.count%p.every == 0 || time.Since(p.last) >= p.interval {
+		p.logPrintf(LevelNormal, "processing %s %d/%d\n", p.what, p.count, p.total)
+		p.last = time.Now()
+	}
+}
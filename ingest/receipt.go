@@ -0,0 +1,79 @@
+package ingest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/georgekinnear/parselearn"
+)
+
+// CheckReceipt reads a single Learn receipt, printing the fields found in it and any problems
+// that would stop it being used, and returns the number of problems
+func CheckReceipt(receiptPath string) int {
+	problems := 0
+	report := func(format string, a ...interface{}) {
+		problems++
+		fmt.Printf(" - "+format+"\n", a...)
+	}
+
+	if _, err := os.Stat(receiptPath); err != nil {
+		report("%v", err)
+		return problems
+	}
+	submission, err := parselearn.ParseLearnReceipt(receiptPath)
+	if err != nil {
+		report("could not be read as a Learn receipt: %v", err)
+		return problems
+	}
+	fmt.Println("fields read from", receiptPath+":")
+	PrettyPrintStruct(submission)
+	fmt.Println("problems:")
+
+	// The UUN is taken from the file name, so it has to be there and agree with the receipt
+	finduun := regexp.MustCompile("(?i)_(s[0-9]{7})_attempt_")
+	name := filepath.Base(receiptPath)
+	match := finduun.FindStringSubmatch(name)
+	if match == nil {
+		report("file name %s has no _s1234567_attempt_ part to read the UUN from", name)
+	}
+	if strings.TrimSpace(submission.UUN) == "" {
+		report("no UUN in the receipt")
+	} else if match != nil && normaliseUUN(submission.UUN) != normaliseUUN(match[1]) {
+		report("the receipt is for %s but the file name has %s", normaliseUUN(submission.UUN), normaliseUUN(match[1]))
+	}
+
+	if strings.TrimSpace(submission.DateSubmitted) == "" {
+		report("no date submitted")
+	} else if _, err := parseSubmissionTime(submission.DateSubmitted, time.Local); err != nil {
+		report("%v; the forms understood are:", err)
+		for _, layout := range submissionTimeLayouts {
+			fmt.Println("     ", layout)
+		}
+	}
+
+	// Each file listed should be alongside the receipt
+	filenames, err := receiptFilenames(receiptPath)
+	if err != nil {
+		report("could not read the file names: %v", err)
+	}
+	if len(filenames) == 0 {
+		report("no files are listed (an empty submission)")
+	}
+	for _, filename := range filenames {
+		path := filepath.Join(filepath.Dir(receiptPath), filename)
+		if _, err := os.Stat(path); err != nil {
+			report("listed file %s is missing: %v", filename, err)
+		} else if err := checkPDF(path); err != nil {
+			report("listed file %s: %v", filename, err)
+		}
+	}
+
+	if problems == 0 {
+		fmt.Println(" (none)")
+	}
+	return problems
+}
@@ -0,0 +1,150 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gocarina/gocsv"
+)
+
+// The forms the reports can be written in, with -reportformat
+var reportFormats = []string{"csv", "tsv", "json"}
+
+// Whether format is one of reportFormats
+func validReportFormat(format string) bool {
+	for _, f := range reportFormats {
+		if format == f {
+			return true
+		}
+	}
+	return false
+}
+
+// Write a report of rows (a pointer to a slice of structs with csv tags) to dir/name, with the
+// extension for the run's report format. Every format has the same columns: TSV is written like the csv but
+// separated by tabs, and JSON as an array with one object per row, keyed by the column names.
+func (r *run) writeReport(dir, name string, rows interface{}) error {
+	records, err := reportRecords(rows)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	switch r.reportFormat {
+	case "json":
+		err = writeJSONRecords(&buf, records)
+	default:
+		w := csv.NewWriter(&buf)
+		if r.reportFormat == "tsv" {
+			// Fields with tabs, quotes or new lines in them are quoted, as they would be in a csv
+			w.Comma = '\t'
+		}
+		err = w.WriteAll(records)
+	}
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, name+"."+r.reportFormat)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, r.outputMode)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// The header and rows of a report, as they would appear in the csv
+func reportRecords(rows interface{}) ([][]string, error) {
+	data, err := gocsv.MarshalBytes(rows)
+	if err != nil {
+		return nil, err
+	}
+	return csv.NewReader(bytes.NewReader(data)).ReadAll()
+}
+
+// Write the rows after the header as an array of JSON objects, keeping the columns in order
+func writeJSONRecords(buf *bytes.Buffer, records [][]string) error {
+	var compact bytes.Buffer
+	compact.WriteString("[")
+	for i := 1; i < len(records); i++ {
+		if i > 1 {
+			compact.WriteString(",")
+		}
+		compact.WriteString("{")
+		for j, column := range records[0] {
+			if j > 0 {
+				compact.WriteString(",")
+			}
+			key, _ := json.Marshal(column)
+			value, _ := json.Marshal(records[i][j])
+			compact.Write(key)
+			compact.WriteString(":")
+			compact.Write(value)
+		}
+		compact.WriteString("}")
+	}
+	compact.WriteString("]")
+	if err := json.Indent(buf, compact.Bytes(), "", "\t"); err != nil {
+		return err
+	}
+	buf.WriteString("\n")
+	return nil
+}
+
+// Read a report written by writeReport in any of the formats, going by its extension,
+// giving each row as a map from column name to value
+func readReport(path string) ([]map[string]string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	contents = bytes.TrimPrefix(contents, []byte{0xEF, 0xBB, 0xBF})
+
+	var rows []map[string]string
+	switch strings.TrimPrefix(filepath.Ext(path), ".") {
+	case "json":
+		err = json.Unmarshal(contents, &rows)
+		return rows, err
+	case "tsv", "csv":
+		r := csv.NewReader(bytes.NewReader(contents))
+		if filepath.Ext(path) == ".tsv" {
+			r.Comma = '\t'
+		}
+		r.FieldsPerRecord = -1
+		records, err := r.ReadAll()
+		if err != nil || len(records) == 0 {
+			return nil, err
+		}
+		for _, record := range records[1:] {
+			row := map[string]string{}
+			for j, column := range records[0] {
+				if j < len(record) {
+					row[column] = record[j]
+				}
+			}
+			rows = append(rows, row)
+		}
+		return rows, nil
+	}
+	return nil, fmt.Errorf("%s is not a csv, tsv or json report", path)
+}
+
+// The version of the SUMMARY line, to be changed if any of its fields are renamed or removed
+const summaryVersion = 1
+
+// SummaryLine is a single line summing up the run, for scripts to read instead of the rest of the output
+func (r IngestResult) SummaryLine() string {
+	return fmt.Sprintf("SUMMARY version=%d successful=%d manual=%d bad=%d wrongformat=%d empty=%d none=%d alreadydone=%d rejected=%d conflicts=%d unknown=%d uunmismatch=%d duplicates=%d missingexamno=%d withdrawn=%d attention=%t stillbeingwritten=%d",
+		summaryVersion, len(r.Submissions), len(r.ManualSubmissions), len(r.BadSubmissions), len(r.WrongFormat), len(r.EmptySubmissions),
+		len(r.NoSubmissions), len(r.AlreadyDone), len(r.Rejected), len(r.Conflicts), len(r.UnknownStudents), len(r.UUNMismatches),
+		len(r.Duplicates), len(r.MissingExamNumber), len(r.Withdrawn), r.NeedsAttention, len(r.StillBeingWritten))
+}
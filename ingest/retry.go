@@ -0,0 +1,44 @@
+package ingest
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+// How many times a file operation is tried, and how long to wait before trying again
+// (the wait doubles after each failure)
+const retryAttempts = 3
+const retryDelay = 200 * time.Millisecond
+
+// Run a file operation, trying it again if it fails in a way that might go away by itself,
+// as happens now and then on busy network filesystems. Each retry is logged to log
+func retry(log logger, op func() error) error {
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt == retryAttempts || !isTransient(err) {
+			return err
+		}
+		log.logPrintf(LevelVerbose, "retrying in %v after error: %v\n", delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// Whether an error might succeed on another attempt; anything else, such as a missing
+// file or a permission problem, should fail straight away
+func isTransient(err error) bool {
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) && timeout.Timeout() {
+		return true
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case syscall.EAGAIN, syscall.EBUSY, syscall.EINTR, syscall.EIO, syscall.ESTALE, syscall.ETIMEDOUT:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package ingest
+
+import (
+	"os"
+	"strings"
+
+	"github.com/georgekinnear/parselearn"
+	"github.com/gocarina/gocsv"
+)
+
+// The start of the made-up receipt name given to each Turnitin submission, since
+// Turnitin has no receipt files; it also marks the submission as coming from Turnitin
+const turnitinReceiptPrefix = "turnitin-"
+
+// A row of the index csv in a Turnitin export, listing one uploaded paper
+type TurnitinUpload struct {
+	StudentID    string `csv:"Student ID"`
+	PaperID      string `csv:"Paper ID"`
+	DateUploaded string `csv:"Date Uploaded"`
+	Filename     string `csv:"File Name"`
+}
+
+// Read the index of a Turnitin export into the same form as the Learn receipts, so that the
+// submissions can be chosen between and checked for lateness in the same way
+func readTurnitinIndex(indexPath string) ([]parselearn.Submission, error) {
+	f, err := os.Open(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var uploads []TurnitinUpload
+	if err := gocsv.Unmarshal(skipBOM(f), &uploads); err != nil {
+		return nil, err
+	}
+	var submissions []parselearn.Submission
+	for _, upload := range uploads {
+		sub := parselearn.Submission{}
+		sub.UUN = normaliseUUN(upload.StudentID)
+		sub.DateSubmitted = strings.TrimSpace(upload.DateUploaded)
+		sub.Filename = strings.TrimSpace(upload.Filename)
+		sub.ReceiptFilename = turnitinReceiptPrefix + strings.TrimSpace(upload.PaperID)
+		if sub.Filename != "" {
+			sub.NumberOfFiles = 1
+		}
+		submissions = append(submissions, sub)
+	}
+	return submissions, nil
+}
+
+// Whether a submission was read from a Turnitin export rather than a Learn receipt
+func isTurnitin(sub parselearn.Submission) bool {
+	return strings.HasPrefix(sub.ReceiptFilename, turnitinReceiptPrefix)
+}
@@ -0,0 +1,292 @@
+package ingest
+
+import (
+	"os"
+	"time"
+
+	"github.com/georgekinnear/parselearn"
+)
+
+// Fields available to the -template flag when naming output files
+type OutputName struct {
+	Course     string
+	ExamNumber string
+	UUN        string
+	Late       bool
+	Sequence   string // zero-padded position of the exam number in the sorted class list
+}
+
+// A submission as it appears in the submission summary report
+type SubmissionSummary struct {
+	parselearn.Submission
+	SubmittedAt  string `csv:"SubmittedAt"`  // DateSubmitted in ISO 8601 format, or blank if it could not be read
+	MinutesLate  int    `csv:"MinutesLate"`  // how long after the student's deadline (including extra time) a LATE submission arrived
+	SupersededBy string `csv:"SupersededBy"` // receipt of the submission that was used instead of a superseded one
+	Attempt      string `csv:"Attempt"`      // the attempt identifier from the Learn file name, which orders a student's attempts
+}
+
+// A submission as it appears in the success report
+type SuccessfulSubmission struct {
+	SourceFile string `csv:"SourceFile"` // the file the student submitted, next to where it went, for checking the right one was picked
+	OutputPath string `csv:"OutputPath"`
+	parselearn.Submission
+	Source   string `csv:"Source"`   // where the submission came from: Learn, Turnitin, Forms or Manual
+	Checksum string `csv:"Checksum"` // SHA-256 of the output file, to show it is exactly what was submitted
+	Pages    string `csv:"Pages"`    // number of pages in the output file, or blank if they could not be counted
+}
+
+// A row of the manifest, which links each output file back to what the student submitted
+type ManifestEntry struct {
+	ExamNumber      string `csv:"ExamNumber"`
+	UUN             string `csv:"UUN"`
+	Filename        string `csv:"Filename"` // the submitted file, or several separated by ; when they were merged
+	ReceiptFilename string `csv:"ReceiptFilename"`
+	OutputPath      string `csv:"OutputPath"`
+	OutputFile      string `csv:"OutputFile"`
+}
+
+// A row of the key that maps exam numbers back to students once marking is done
+type ExamNumberKey struct {
+	ExamNumber string `csv:"ExamNumber"`
+	UUN        string `csv:"UUN"`
+}
+
+// A row of the duplicate content report, for one of the students whose output files are identical
+type DuplicateContent struct {
+	Checksum   string `csv:"Checksum"`
+	UUN        string `csv:"UUN"`
+	ExamNumber string `csv:"ExamNumber"`
+	OutputPath string `csv:"OutputPath"`
+}
+
+// The number of students in the class list, and in each of the reports
+type Reconciliation struct {
+	ClassList         int `csv:"ClassList" json:"classlist"`
+	Successful        int `csv:"Successful" json:"successful"`
+	Manual            int `csv:"Manual" json:"manual"`
+	Bad               int `csv:"Bad" json:"bad"`
+	WrongFormat       int `csv:"WrongFormat" json:"wrongformat"`
+	Empty             int `csv:"Empty" json:"empty"`
+	NoSubmission      int `csv:"NoSubmission" json:"nosubmission"`
+	AlreadyDone       int `csv:"AlreadyDone" json:"alreadydone"`
+	StillBeingWritten int `csv:"StillBeingWritten" json:"stillbeingwritten"`
+	Conflicts         int `csv:"Conflicts" json:"conflicts"` // students whose existing output file was kept by -nooverwrite
+}
+
+// The number of students who appear in one of the reports
+func (r Reconciliation) Accounted() int {
+	return r.Successful + r.Manual + r.Bad + r.WrongFormat + r.Empty + r.NoSubmission + r.AlreadyDone + r.StillBeingWritten + r.Conflicts
+}
+
+// Whether every student in the class list appears in exactly one report
+func (r Reconciliation) Balanced() bool {
+	return r.Accounted() == r.ClassList
+}
+
+// How the students with extra time used it, based on the submission used for each of them
+type ExtraTimeUsage struct {
+	BeforeDeadline int `json:"beforedeadline"` // submitted before the normal deadline
+	UsedExtraTime  int `json:"usedextratime"`  // submitted after the normal deadline, but within their extra time
+	RanOver        int `json:"ranover"`        // submitted after their extra time
+}
+
+// How close to their deadline the students made the submissions that were used, to show
+// whether submissions (and so the load on Learn) bunch up at the last minute
+type SubmissionTimes struct {
+	Early           int // more than an hour before the student's deadline
+	LastHour        int // in the hour before the student's deadline
+	WithinExtraTime int // after the deadline, but within the student's extra time
+	Late            int // after the student's extra time (or deadline, with none)
+}
+
+// Count a submission made at submittedAt, for a student with the given deadline and minutes of extra time
+func (t *SubmissionTimes) add(submittedAt time.Time, deadline time.Time, extraTimeMinutes int) {
+	switch {
+	case submittedAt.Before(deadline.Add(-time.Hour)):
+		t.Early++
+	case !submittedAt.After(deadline):
+		t.LastHour++
+	case !isLate(submittedAt, deadline, extraTimeMinutes):
+		t.WithinExtraTime++
+	default:
+		t.Late++
+	}
+}
+
+// One row of the submission times report
+type SubmissionTimesBucket struct {
+	Bucket   string `csv:"Bucket"`
+	Students int    `csv:"Students"`
+}
+
+// The submission times as rows for the report, in order from earliest to latest
+func (t SubmissionTimes) buckets() []SubmissionTimesBucket {
+	return []SubmissionTimesBucket{
+		{"More than 1 hour early", t.Early},
+		{"In the last hour", t.LastHour},
+		{"Late within extra time", t.WithinExtraTime},
+		{"Late beyond extra time", t.Late},
+	}
+}
+
+// How many students would be late if the normal deadline were changed, from -simulatedeadlines
+type DeadlineSimulation struct {
+	Deadline     string `csv:"Deadline"`
+	OnTime       int    `csv:"OnTime"`       // students with at least one submission before their deadline
+	Late         int    `csv:"Late"`         // students whose submissions were all after their deadline
+	NoSubmission int    `csv:"NoSubmission"` // students with no Learn or Turnitin submission
+	Unknown      int    `csv:"Unknown"`      // students whose submission times couldn't be read
+}
+
+// Structure of the JSON summary report written with -jsonreport
+type JSONReport struct {
+	Deadline       string `json:"deadline"`
+	FilesProcessed int    `json:"filesprocessed"` // files moved into place in this run
+	BytesProcessed int64  `json:"bytesprocessed"` // their total size
+	Reconciliation
+	ExtraTimeUsage ExtraTimeUsage      `json:"extratime"`
+	Submissions    []SubmissionSummary `json:"submissions"`
+}
+
+// Structure for the -groupdeadlines csv, giving the deadline for one group of students
+type GroupDeadline struct {
+	Group    string `csv:"Group"`
+	Deadline string `csv:"Deadline"`
+}
+
+// Structure for the -concessions csv, giving extra time granted after the class list was made
+type Concession struct {
+	StudentID     string `csv:"UUN"`
+	ExtraTimeText string `csv:"Extra Time"`
+	ExtraTime     int    `csv:"-"`
+	Note          string `csv:"Note"`
+}
+
+// Structure for the -overrides csv, giving the file to use for a student
+type OverrideFile struct {
+	StudentID string `csv:"UUN"`
+	Path      string `csv:"File"`
+}
+
+// Structure for the csv listing the files uploaded to MS Forms
+type FormsUpload struct {
+	StudentID string `csv:"UUN"`
+	Filename  string `csv:"Filename"`
+}
+
+// The names of the class list columns that each student's details are read from
+type ClassListColumns struct {
+	UUN        string
+	ExamNumber string
+	ExtraTime  string
+	Deadline   string
+	Status     string
+	Group      string
+
+	ExamNumberOptional bool // when the exam numbers can come from elsewhere, such as -examnomap
+	ExtraTimeRequired  bool // when the extra time column was named with -col-extratime, so a mistyped name is an error
+}
+
+// The settings for a run of Ingest, which are given by the flags of the same names
+type IngestOptions struct {
+	Course            string
+	ClassListCSV      string // several files can be given, separated by commas
+	ClassListColumns  ClassListColumns
+	NoClassList       bool   // process everyone with a receipt, using their UUN as the exam number
+	LearnDir          string // a folder, or the zip file downloaded from Learn
+	OtherDirs         []string
+	OutputDir         string
+	Deadline          string
+	Concessions       string // csv of UUN, Extra Time, Note replacing the class list's extra time for those students
+	GroupDeadlines    string // csv of Group, Deadline for groups whose deadline differs from Deadline
+	SimulateDeadlines string // comma-separated deadlines to count the late students at, instead of processing anything
+	TimeZone          string // blank for the system's local time zone
+	GracePeriod       time.Duration
+	HardCutoff        time.Duration // submissions this long after a student's deadline are rejected (0 for no cutoff)
+	Since             string
+	MinAge            time.Duration // files modified more recently than this are left for a later run
+	MinBytes          int64
+	AuditLog          string
+	ExamNoMap         string
+	HashSalt          string // students with no exam number are given one made from a salted hash of their UUN
+	Overrides         string // csv of UUN, File giving the file to use for particular students
+	Only              string
+	Accommodations    string // UUNs of students with an accommodation, to check they have extra time
+	TurnitinDir       string
+	TurnitinCSV       string // default TurnitinDir/index.csv
+	FormsDir          string
+	FormsCSV          string
+	FilenameTemplate  string
+	SortBy            string // the order students are processed and reported in: uun (the default) or examno
+	LatePrefix        string
+	LateSuffix        string
+	SequenceNumbers   bool
+	Workers           int
+	CopyOnly          bool
+	KeepTemp          bool
+	AcceptLate        bool
+	MaxMergeFiles     int
+	MergePDFs         bool
+	CoverSheet        bool // start each new output file with a cover sheet for the marker
+	Strict            bool
+	Resume            bool
+	ReportsOnly       bool // write the reports from the output files already in OutputDir, touching nothing else
+	HardLink          bool
+	NoOverwrite       bool
+	PreserveMtime     bool
+	OutputMode        os.FileMode // permissions for output files and reports (default 0640)
+	StripMetadata     bool
+	RequireClean      bool
+	KeepReceipts      bool
+	KeepLate          bool // move late submissions that aren't used to OutputDir/late, rather than deleting them
+	AnonymiseReceipts bool // rename kept receipts after the output file, so they don't show the UUN
+	InPlace           bool // rename submissions where they are, rather than moving them to OutputDir
+	Debug             bool
+	ReportFormat      string // csv (the default), tsv or json
+	JSONReport        bool
+	LogLevel          int // how much is printed to the console: LevelQuiet (the default), LevelNormal or LevelVerbose
+}
+
+// What happened to each student in a run of Ingest, as written to the reports
+type IngestResult struct {
+	Submissions       []parselearn.Submission
+	ManualSubmissions []parselearn.Submission
+	BadSubmissions    []parselearn.Submission
+	EmptySubmissions  []parselearn.Submission
+	WrongFormat       []parselearn.Submission
+	NoSubmissions     []parselearn.Submission
+	AlreadyDone       []parselearn.Submission
+	StillBeingWritten []parselearn.Submission // students with files newer than MinAge, left for a later run
+	Conflicts         []parselearn.Submission
+	Rejected          []parselearn.Submission
+	TooManyFiles      []parselearn.Submission
+	MissingExamNumber []Students
+	Withdrawn         []Students
+	UnknownStudents   []parselearn.Submission // submissions from UUNs not in the class list, which are left in place
+	MissingExtraTime  []string                // students with an accommodation but no extra time, who were marked LATE
+	UUNMismatches     []parselearn.Submission // receipts naming a different UUN from their file name, which are left in place
+	Duplicates        []DuplicateContent      // students whose submitted files are byte-for-byte the same as another's
+	Leftovers         []string                // files left in the input folders, when RequireClean is set
+	Reconciliation    Reconciliation
+	ExtraTimeUsage    ExtraTimeUsage
+	SubmissionTimes   SubmissionTimes
+	FilesProcessed    int   // files moved into place in this run
+	BytesProcessed    int64 // their total size
+	ReportDir         string
+	Simulations       []DeadlineSimulation // with SimulateDeadlines, the only thing filled in
+	NeedsAttention    bool                 // whether anything needs sorting out by hand, so the run should count as failed
+}
+
+// SetupError is an error that stopped Ingest before any student's files were dealt with, such as
+// a bad option or a class list that could not be read, so nothing has been moved
+type SetupError struct {
+	Err error
+}
+
+func (e *SetupError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SetupError) Unwrap() error {
+	return e.Err
+}
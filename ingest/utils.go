@@ -0,0 +1,484 @@
+package ingest
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	pdf "github.com/unidoc/unipdf/model"
+)
+
+// pr-pal @ https://stackoverflow.com/questions/37932551/mkdir-if-not-exists-using-golang
+func ensureDir(dirName string) error {
+
+	err := os.Mkdir(dirName, 0700) //probably umasked with 22 not 02
+
+	os.Chmod(dirName, 0700)
+
+	if err == nil || os.IsExist(err) {
+		return nil
+	} else {
+		return err
+	}
+
+}
+
+func countPages(inputPath string) (int, error) {
+
+	numPages := 0
+
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return numPages, err
+	}
+
+	pdfReader, err := pdf.NewPdfReader(f)
+	if err != nil {
+		return numPages, err
+	}
+
+	defer f.Close()
+
+	isEncrypted, err := pdfReader.IsEncrypted()
+	if err != nil {
+		return numPages, err
+	}
+
+	if isEncrypted {
+		_, err = pdfReader.Decrypt([]byte(""))
+		if err != nil {
+			return numPages, err
+		}
+	}
+
+	numPages, err = pdfReader.GetNumPages()
+	if err != nil {
+		return numPages, err
+	}
+
+	return numPages, nil
+
+}
+
+// Check that a file looks like a PDF that markers will be able to open, i.e. it
+// starts with a PDF header and has no /Encrypt dictionary
+func checkPDF(inputPath string) error {
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(f, header); err != nil || string(header) != "%PDF-" {
+		return errors.New("Not a valid PDF (bad header)")
+	}
+
+	// Look through the file for /Encrypt, keeping the end of each chunk in case it is split across two
+	encrypt := []byte("/Encrypt")
+	buf := make([]byte, 64*1024)
+	carry := []byte{}
+	for {
+		n, err := f.Read(buf)
+		chunk := append(carry, buf[:n]...)
+		if bytes.Contains(chunk, encrypt) {
+			return errors.New("PDF is encrypted or password protected")
+		}
+		if len(chunk) >= len(encrypt) {
+			carry = append([]byte{}, chunk[len(chunk)-len(encrypt)+1:]...)
+		} else {
+			carry = chunk
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Read the names of the submitted files listed in a Learn receipt, in the order they were uploaded
+func receiptFilenames(receiptPath string) ([]string, error) {
+	contents, err := os.ReadFile(receiptPath)
+	if err != nil {
+		return nil, err
+	}
+	var filenames []string
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "Filename:") {
+			filenames = append(filenames, strings.TrimSpace(strings.TrimPrefix(line, "Filename:")))
+		}
+	}
+	return filenames, nil
+}
+
+// Join the pages of several PDFs together, in order, into a new PDF at outputPath
+func mergePDFs(inputPaths []string, outputPath string) error {
+	pdfWriter := pdf.NewPdfWriter()
+
+	for _, inputPath := range inputPaths {
+		// The input files have to stay open until the merged file is written
+		f, err := os.Open(inputPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		pdfReader, err := pdf.NewPdfReader(f)
+		if err != nil {
+			return err
+		}
+		numPages, err := pdfReader.GetNumPages()
+		if err != nil {
+			return err
+		}
+		for i := 1; i <= numPages; i++ {
+			page, err := pdfReader.GetPage(i)
+			if err != nil {
+				return err
+			}
+			if err := pdfWriter.AddPage(page); err != nil {
+				return err
+			}
+		}
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return pdfWriter.Write(out)
+}
+
+// Check that a file name is safe to use on any filesystem, i.e. it is not a path
+// and has no characters that Windows or macOS would object to
+func checkSafeFilename(name string) error {
+	if name == "" || name == "." || name == ".." {
+		return fmt.Errorf("%q is not a file name", name)
+	}
+	for _, c := range name {
+		if c < 32 || strings.ContainsRune(`<>:"/\|?*`, c) {
+			return fmt.Errorf("%q contains the character %q", name, c)
+		}
+	}
+	return nil
+}
+
+// Work out the extension a file should have from the first few bytes of its contents,
+// or "" if the type is not recognised
+func detectExtension(inputPath string) (string, error) {
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	header := make([]byte, 8)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte("%PDF-")):
+		return ".pdf", nil
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")):
+		// Word, Excel and PowerPoint files are zip files, told apart by the folders inside
+		return officeExtension(inputPath), nil
+	case bytes.HasPrefix(header, []byte("\xD0\xCF\x11\xE0")):
+		return ".doc", nil
+	case bytes.HasPrefix(header, []byte("\xFF\xD8\xFF")):
+		return ".jpg", nil
+	case bytes.HasPrefix(header, []byte("\x89PNG")):
+		return ".png", nil
+	case bytes.HasPrefix(header, []byte("GIF8")):
+		return ".gif", nil
+	}
+	return "", nil
+}
+
+// The extension of an Office document stored as a zip file, or .zip if it is some other zip file
+func officeExtension(inputPath string) string {
+	archive, err := zip.OpenReader(inputPath)
+	if err != nil {
+		return ".zip"
+	}
+	defer archive.Close()
+	for _, f := range archive.File {
+		switch {
+		case strings.HasPrefix(f.Name, "word/"):
+			return ".docx"
+		case strings.HasPrefix(f.Name, "xl/"):
+			return ".xlsx"
+		case strings.HasPrefix(f.Name, "ppt/"):
+			return ".pptx"
+		}
+	}
+	return ".zip"
+}
+
+// Whether a file type found by detectExtension is an Office document or an image, which
+// a student has most likely renamed to .pdf and can be asked to resubmit as a PDF
+func isWrongFormat(ext string) bool {
+	switch ext {
+	case ".doc", ".docx", ".xlsx", ".pptx", ".jpg", ".png", ".gif":
+		return true
+	}
+	return false
+}
+
+// Describe a file type found by detectExtension, for use in reports
+func describeExtension(ext string) string {
+	if ext == "" {
+		return "an unknown type"
+	}
+	return "a " + ext + " file"
+}
+
+// A file name with its extension replaced by ext (from detectExtension), or unchanged if ext is blank
+func withExtension(name string, ext string) string {
+	if ext == "" {
+		return name
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ext
+}
+
+// Whether a file name ends in exactly one, lowercase, .pdf
+func hasPDFExtension(name string) bool {
+	return strings.HasSuffix(name, ".pdf") && !strings.HasSuffix(strings.ToLower(name), ".pdf.pdf")
+}
+
+// Skip the UTF-8 byte order mark that Excel puts at the start of csv files, which
+// would otherwise become part of the first column name (so "UUN" would not be found)
+func skipBOM(r io.Reader) io.Reader {
+	reader := bufio.NewReader(r)
+	if start, err := reader.Peek(3); err == nil && bytes.Equal(start, []byte{0xEF, 0xBB, 0xBF}) {
+		reader.Discard(3)
+	}
+	return reader
+}
+
+func checkMatriculation(m string) (bool, error) {
+	expectedLength := 8
+	actualLength := len(m)
+	if actualLength != expectedLength {
+		return false, errors.New(fmt.Sprintf("Wrong length got %d not %d", actualLength, expectedLength))
+	}
+	if strings.HasPrefix(strings.ToLower(m), "s") {
+		return false, errors.New(fmt.Sprintf("Does not start with s"))
+	}
+	return true, nil
+}
+func checkExamNumber(m string) (bool, error) {
+	expectedLength := 7
+	actualLength := len(m)
+	if actualLength != expectedLength {
+		return false, errors.New(fmt.Sprintf("Wrong length got %d not %d", actualLength, expectedLength))
+	}
+
+	if strings.HasPrefix(strings.ToLower(m), "b") {
+		return false, errors.New(fmt.Sprintf("Does not start with b"))
+
+	}
+	return true, nil
+}
+
+// The ways of writing hours and minutes accepted in extra time, and the units they stand for
+var extraTimeUnits = strings.NewReplacer("hours", "h", "hour", "h", "hrs", "h", "hr", "h", "minutes", "m", "minute", "m", "mins", "m", "min", "m")
+
+// Read the number of minutes of extra time from the class list, where a blank means no extra time.
+// It can be given as a plain number of minutes (e.g. 90), or with units (e.g. 90m, 1.5h or 1h30m).
+func parseExtraTime(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	minutes, err := strconv.Atoi(value)
+	if err != nil {
+		duration, err := time.ParseDuration(extraTimeUnits.Replace(strings.ToLower(strings.Join(strings.Fields(value), ""))))
+		if err != nil || duration%time.Minute != 0 {
+			return 0, fmt.Errorf("%q is not a whole number of minutes (or a time such as 90m or 1.5h)", value)
+		}
+		minutes = int(duration / time.Minute)
+	}
+	if minutes < 0 {
+		return 0, fmt.Errorf("%d minutes is negative", minutes)
+	}
+	return minutes, nil
+}
+
+// Put a UUN in the form used as a key throughout, e.g. "s1234567" becomes "S1234567"
+func normaliseUUN(uun string) string {
+	uun = strings.ToUpper(strings.TrimSpace(uun))
+	if !strings.HasPrefix(uun, "S") {
+		// prepend an "S" to the UUN if not there already
+		uun = "S" + uun
+	}
+	return uun
+}
+
+// The exam number with any leading zeros taken off, so that numbers which differ only by
+// leading zeros (e.g. 0042 and 42) can be spotted. Exam numbers themselves are always kept
+// as the text in the class list, zeros and all.
+func examNumberDigits(examno string) string {
+	digits := strings.TrimLeft(examno, "0")
+	if digits == "" && examno != "" {
+		return "0"
+	}
+	return digits
+}
+
+// The number of hex digits of the HMAC kept in an exam number made by hashedExamNumber
+const hashedExamNumberLength = 10
+
+// An anonymous exam number for a student who has none, made from an HMAC-SHA256 of their UUN
+// keyed with the salt, so that the same salt always gives the same student the same number
+func hashedExamNumber(salt string, uun string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(normaliseUUN(uun)))
+	return hex.EncodeToString(mac.Sum(nil))[:hashedExamNumberLength]
+}
+
+// Read a list of UUNs, given either as a comma-separated list or as the path of a file
+// with the UUNs separated by commas or on separate lines
+func parseUUNList(value string) (map[string]bool, error) {
+	if info, err := os.Stat(value); err == nil && !info.IsDir() {
+		contents, err := os.ReadFile(value)
+		if err != nil {
+			return nil, err
+		}
+		value = string(contents)
+	}
+	uuns := map[string]bool{}
+	for _, uun := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+		if strings.TrimSpace(uun) != "" {
+			uuns[normaliseUUN(uun)] = true
+		}
+	}
+	return uuns, nil
+}
+
+// List the files left anywhere in the given folders
+func leftoverFiles(dirs []string) []string {
+	var leftovers []string
+	for _, dir := range dirs {
+		filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+			if err == nil && f.Mode().IsRegular() {
+				leftovers = append(leftovers, path)
+			}
+			return nil
+		})
+	}
+	return leftovers
+}
+
+// Read a deadline given either in the form 2020-04-22-16-00 (in the exam's time zone, loc)
+// or as an RFC3339 time such as 2020-04-22T16:00:00+01:00
+func parseDeadline(value string, loc *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.ParseInLocation("2006-01-02-15-04", value, loc); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not in the form 2020-04-22-16-00 or 2020-04-22T16:00:00+01:00", value)
+}
+
+// Find the UUNs of everyone with a Learn receipt anywhere in the given folders, using
+// finduun to read the UUN from the name of each receipt
+func receiptUUNs(dirs []string, finduun *regexp.Regexp) map[string]bool {
+	uuns := map[string]bool{}
+	for _, dir := range dirs {
+		filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+			if err == nil && !f.IsDir() && strings.HasSuffix(strings.ToLower(f.Name()), ".txt") {
+				if match := finduun.FindStringSubmatch(f.Name()); match != nil {
+					uuns[normaliseUUN(match[1])] = true
+				}
+			}
+			return nil
+		})
+	}
+	return uuns
+}
+
+// The attempt identifier in the name of a Learn receipt, e.g. "2020-04-22-15-58-12" from
+// MATH00000_s1234567_attempt_2020-04-22-15-58-12.txt, or "" if there isn't one
+func learnAttempt(receiptFilename string) string {
+	i := strings.Index(receiptFilename, "_attempt_")
+	if i < 0 {
+		return ""
+	}
+	attempt := receiptFilename[i+len("_attempt_"):]
+	return strings.TrimSuffix(attempt, filepath.Ext(attempt))
+}
+
+// The forms that the date a submission was made has been seen in on Learn receipts,
+// starting with the one used throughout this tool
+var submissionTimeLayouts = []string{
+	"2006-01-02-15-04-05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+	"02/01/2006 15:04:05",
+	"Monday, 2 January 2006 15:04:05 o'clock MST",
+	"Monday, January 2, 2006 3:04:05 PM MST",
+}
+
+// Read the date a submission was made, in whichever form the receipt gives it, taking
+// a date with no time zone of its own to be in loc. A time zone abbreviation is only
+// trusted if it is UTC or GMT or one that loc uses, since Go takes any other (such as
+// BST when loc is UTC) to be zero hours from UTC
+func parseSubmissionTime(value string, loc *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range submissionTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			if name, _ := t.Zone(); strings.HasSuffix(layout, "MST") && t.Location() != loc && name != "UTC" && name != "GMT" {
+				return time.Time{}, fmt.Errorf("the date submitted %q is in time zone %s, which is not one used in %s (set -timezone to match)", value, name, loc)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("the date submitted %q is not in a known form", value)
+}
+
+// Rewrite a PDF with just its pages, leaving out the document information (Author, Title,
+// Subject, Keywords) and XMP metadata, which can give away who the student is
+func stripPDFMetadata(path string, mode os.FileMode) (err error) {
+	defer func() {
+		// The PDF library can panic on badly broken files
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	if err = mergePDFs([]string{path}, tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	os.Chmod(tmp.Name(), mode)
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,178 @@
+package ingest
+
+import (
+	"testing"
+	"time"
+)
+
+// Europe/London, which goes from GMT to BST at 01:00 UTC on 2020-03-29 and back at 01:00 UTC on 2020-10-25
+func london(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	return loc
+}
+
+func TestParseDeadlineClockChange(t *testing.T) {
+	loc := london(t)
+	tests := []struct {
+		deadline string
+		utc      time.Time
+	}{
+		{"2020-03-29-00-30", time.Date(2020, 3, 29, 0, 30, 0, 0, time.UTC)}, // GMT, before the clocks go forward
+		{"2020-03-29-02-30", time.Date(2020, 3, 29, 1, 30, 0, 0, time.UTC)}, // BST, after
+		{"2020-10-25-00-30", time.Date(2020, 10, 24, 23, 30, 0, 0, time.UTC)},
+		{"2020-10-25-02-30", time.Date(2020, 10, 25, 2, 30, 0, 0, time.UTC)},
+		{"2020-03-29T02:30:00+01:00", time.Date(2020, 3, 29, 1, 30, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		got, err := parseDeadline(test.deadline, loc)
+		if err != nil {
+			t.Errorf("parseDeadline(%q): %v", test.deadline, err)
+		} else if !got.Equal(test.utc) {
+			t.Errorf("parseDeadline(%q) = %s, want %s", test.deadline, got.UTC(), test.utc)
+		}
+	}
+}
+
+func TestParseSubmissionTimeClockChange(t *testing.T) {
+	loc := london(t)
+	tests := []struct {
+		submitted string
+		utc       time.Time
+	}{
+		{"2020-03-29 00:59:00", time.Date(2020, 3, 29, 0, 59, 0, 0, time.UTC)},
+		{"2020-03-29 02:00:00", time.Date(2020, 3, 29, 1, 0, 0, 0, time.UTC)},
+		{"2020-03-29-02-10-00", time.Date(2020, 3, 29, 1, 10, 0, 0, time.UTC)},
+		{"2020-10-25 00:50:00", time.Date(2020, 10, 24, 23, 50, 0, 0, time.UTC)},
+		{"2020-10-25 02:10:00", time.Date(2020, 10, 25, 2, 10, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		got, err := parseSubmissionTime(test.submitted, loc)
+		if err != nil {
+			t.Errorf("parseSubmissionTime(%q): %v", test.submitted, err)
+		} else if !got.Equal(test.utc) {
+			t.Errorf("parseSubmissionTime(%q) = %s, want %s", test.submitted, got.UTC(), test.utc)
+		}
+	}
+}
+
+// A time zone abbreviation on the receipt is only used if it is one the time zone uses
+func TestParseSubmissionTimeZoneName(t *testing.T) {
+	submitted := "Wednesday, 22 April 2020 15:51:42 o'clock BST"
+	got, err := parseSubmissionTime(submitted, london(t))
+	if err != nil {
+		t.Errorf("parseSubmissionTime(%q) in Europe/London: %v", submitted, err)
+	} else if want := time.Date(2020, 4, 22, 14, 51, 42, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("parseSubmissionTime(%q) in Europe/London = %s, want %s", submitted, got.UTC(), want)
+	}
+	if got, err := parseSubmissionTime(submitted, time.UTC); err == nil {
+		t.Errorf("parseSubmissionTime(%q) in UTC = %s, want an error", submitted, got.UTC())
+	}
+	submitted = "Wednesday, 22 April 2020 15:51:42 o'clock GMT"
+	got, err = parseSubmissionTime(submitted, time.UTC)
+	if err != nil {
+		t.Errorf("parseSubmissionTime(%q) in UTC: %v", submitted, err)
+	} else if want := time.Date(2020, 4, 22, 15, 51, 42, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("parseSubmissionTime(%q) in UTC = %s, want %s", submitted, got.UTC(), want)
+	}
+}
+
+// Extra time that runs across the clock change is real time, not time on the clock
+func TestIsLateClockChange(t *testing.T) {
+	loc := london(t)
+	tests := []struct {
+		name      string
+		deadline  string
+		extraTime int
+		submitted string
+		late      bool
+	}{
+		// 00:45 GMT plus 30 minutes is 02:15 BST
+		{"spring, within extra time", "2020-03-29-00-45", 30, "2020-03-29 02:10:00", false},
+		{"spring, after extra time", "2020-03-29-00-45", 30, "2020-03-29 02:20:00", true},
+		{"spring, no extra time", "2020-03-29-00-45", 0, "2020-03-29 00:50:00", true},
+		// 00:30 BST plus 2 hours is 01:30 GMT
+		{"autumn, before the deadline", "2020-10-25-00-30", 120, "2020-10-25 00:20:00", false},
+		{"autumn, after extra time", "2020-10-25-00-30", 120, "2020-10-25 02:10:00", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deadline, err := parseDeadline(test.deadline, loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			submitted, err := parseSubmissionTime(test.submitted, loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if late := isLate(submitted, deadline, test.extraTime); late != test.late {
+				t.Errorf("submitted %s for a deadline of %s with %d minutes extra time: got late %t, want %t",
+					test.submitted, test.deadline, test.extraTime, late, test.late)
+			}
+		})
+	}
+}
+
+func TestNormaliseUUN(t *testing.T) {
+	tests := []struct {
+		uun  string
+		want string
+	}{
+		{"s1234567", "S1234567"},
+		{"S1234567", "S1234567"},
+		{" s1234567 ", "S1234567"},
+		{"1234567", "S1234567"},
+	}
+	for _, test := range tests {
+		if got := normaliseUUN(test.uun); got != test.want {
+			t.Errorf("normaliseUUN(%q) = %q, want %q", test.uun, got, test.want)
+		}
+	}
+}
+
+func TestParseExtraTime(t *testing.T) {
+	tests := []struct {
+		value   string
+		minutes int
+		ok      bool
+	}{
+		{"", 0, true},
+		{"90", 90, true},
+		{"90m", 90, true},
+		{"1.5h", 90, true},
+		{"1h30m", 90, true},
+		{"1.5 hours", 90, true},
+		{"20 mins", 20, true},
+		{"1.5", 0, false},
+		{"0.5m", 0, false},
+		{"-10", 0, false},
+		{"lots", 0, false},
+	}
+	for _, test := range tests {
+		got, err := parseExtraTime(test.value)
+		if (err == nil) != test.ok {
+			t.Errorf("parseExtraTime(%q): error %v, want ok %t", test.value, err, test.ok)
+		} else if got != test.minutes {
+			t.Errorf("parseExtraTime(%q) = %d, want %d", test.value, got, test.minutes)
+		}
+	}
+}
+
+func TestHashedExamNumber(t *testing.T) {
+	examno := hashedExamNumber("salt", "s1234567")
+	if len(examno) != hashedExamNumberLength {
+		t.Errorf("hashedExamNumber gave %q, want %d hex digits", examno, hashedExamNumberLength)
+	}
+	if again := hashedExamNumber("salt", "S1234567"); again != examno {
+		t.Errorf("the same student with the same salt got %q and %q", examno, again)
+	}
+	if other := hashedExamNumber("salt", "s7654321"); other == examno {
+		t.Errorf("two students got the same exam number %q", examno)
+	}
+	if other := hashedExamNumber("pepper", "s1234567"); other == examno {
+		t.Errorf("two salts gave the same exam number %q", examno)
+	}
+}
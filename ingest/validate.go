@@ -0,0 +1,79 @@
+package ingest
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ValidateClassList checks the class list csv files are well-formed, printing any problems found
+// (and with logLevel LevelNormal or more, what was read), and returns the number of problems
+func ValidateClassList(classListPaths []string, columns ClassListColumns, logLevel int) int {
+	log := logger{level: logLevel}
+	problems := 0
+	report := func(format string, a ...interface{}) {
+		problems++
+		fmt.Printf(" - "+format+"\n", a...)
+	}
+
+	uun_seen := map[string]string{}    // UUN -> where it was first seen
+	examno_seen := map[string]string{} // exam number -> UUN it belongs to
+	digits_seen := map[string]string{} // exam number, without leading zeros -> UUN it belongs to
+	rows := 0
+	for _, classListPath := range classListPaths {
+		classListFile, err := os.Open(classListPath)
+		if err != nil {
+			report("%v", err)
+			continue
+		}
+		classlist_raw, err := readClassListCSV(classListFile, columns)
+		classListFile.Close()
+		if err != nil {
+			report("%s could not be read: %v", classListPath, err)
+			continue
+		}
+		log.logPrintln(LevelNormal, "class list csv: ", classListPath, "has", len(classlist_raw), "rows")
+
+		for i, s := range classlist_raw {
+			rows++
+			// Row 1 is the header, so the first student is on row 2
+			where := fmt.Sprintf("%s row %d", classListPath, i+2)
+
+			if strings.TrimSpace(s.StudentID) == "" {
+				report("%s: missing UUN", where)
+				continue
+			}
+			uun := normaliseUUN(s.StudentID)
+			if first, ok := uun_seen[uun]; ok {
+				report("%s: duplicate UUN %s (also in %s)", where, uun, first)
+			} else {
+				uun_seen[uun] = where
+			}
+
+			examno := strings.TrimSpace(s.ExamNumber)
+			if examno == "" {
+				report("%s: missing exam number for %s", where, uun)
+			} else if other, ok := examno_seen[examno]; ok && other != uun {
+				report("%s: duplicate exam number %s (also used by %s)", where, examno, other)
+			} else if other, ok := digits_seen[examNumberDigits(examno)]; ok && other != uun {
+				report("%s: exam number %s is also used by %s (leading zeros are not enough to tell them apart)", where, examno, other)
+			} else {
+				examno_seen[examno] = uun
+				digits_seen[examNumberDigits(examno)] = uun
+			}
+
+			if _, err := parseExtraTime(s.ExtraTimeText); err != nil {
+				report("%s: extra time for %s %v", where, uun, err)
+			}
+			if deadline := strings.TrimSpace(s.DeadlineText); deadline != "" {
+				if _, err := parseDeadline(deadline, time.Local); err != nil {
+					report("%s: deadline for %s %v", where, uun, err)
+				}
+			}
+		}
+	}
+
+	log.logPrintln(LevelNormal, "class list contains", rows, "rows and", len(uun_seen), "students")
+	return problems
+}
@@ -0,0 +1,33 @@
+package ingest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateClassListLeadingZeros(t *testing.T) {
+	columns := ClassListColumns{UUN: "UUN", ExamNumber: "Exam Number", ExtraTime: "Extra Time"}
+	tests := []struct {
+		name      string
+		classlist string
+		problems  int
+	}{
+		{"leading zeros kept", "UUN,Exam Number\ns1234567,0042\ns7654321,0043\n", 0},
+		{"same apart from leading zeros", "UUN,Exam Number\ns1234567,0042\ns7654321,42\n", 1},
+		{"same exam number", "UUN,Exam Number\ns1234567,0042\ns7654321,0042\n", 1},
+		{"same exam number and one with leading zeros", "UUN,Exam Number\ns1234567,0042\ns7654321,42\ns2222222,42\n", 2},
+		{"all zeros", "UUN,Exam Number\ns1234567,0\ns7654321,000\n", 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "classlist.csv")
+			if err := os.WriteFile(path, []byte(test.classlist), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if problems := ValidateClassList([]string{path}, columns, LevelQuiet); problems != test.problems {
+				t.Errorf("got %d problems, want %d", problems, test.problems)
+			}
+		})
+	}
+}
@@ -1,87 +0,0 @@
-package main
-
-import (
-	"errors"
-	"fmt"
-	"os"
-	"strings"
-
-	pdf "github.com/unidoc/unipdf/model"
-)
-
-// pr-pal @ https://stackoverflow.com/questions/37932551/mkdir-if-not-exists-using-golang
-func ensureDir(dirName string) error {
-
-	err := os.Mkdir(dirName, 0700) //probably umasked with 22 not 02
-
-	os.Chmod(dirName, 0700)
-
-	if err == nil || os.IsExist(err) {
-		return nil
-	} else {
-		return err
-	}
-
-}
-
-func countPages(inputPath string) (int, error) {
-
-	numPages := 0
-
-	f, err := os.Open(inputPath)
-	if err != nil {
-		return numPages, err
-	}
-
-	pdfReader, err := pdf.NewPdfReader(f)
-	if err != nil {
-		return numPages, err
-	}
-
-	defer f.Close()
-
-	isEncrypted, err := pdfReader.IsEncrypted()
-	if err != nil {
-		return numPages, err
-	}
-
-	if isEncrypted {
-		_, err = pdfReader.Decrypt([]byte(""))
-		if err != nil {
-			return numPages, err
-		}
-	}
-
-	numPages, err = pdfReader.GetNumPages()
-	if err != nil {
-		return numPages, err
-	}
-
-	return numPages, nil
-
-}
-
-func checkMatriculation(m string) (bool, error) {
-	expectedLength := 8
-	actualLength := len(m)
-	if actualLength != expectedLength {
-		return false, errors.New(fmt.Sprintf("Wrong length got %d not %d", actualLength, expectedLength))
-	}
-	if strings.HasPrefix(strings.ToLower(m), "s") {
-		return false, errors.New(fmt.Sprintf("Does not start with s"))
-	}
-	return true, nil
-}
-func checkExamNumber(m string) (bool, error) {
-	expectedLength := 7
-	actualLength := len(m)
-	if actualLength != expectedLength {
-		return false, errors.New(fmt.Sprintf("Wrong length got %d not %d", actualLength, expectedLength))
-	}
-
-	if strings.HasPrefix(strings.ToLower(m), "b") {
-		return false, errors.New(fmt.Sprintf("Does not start with b"))
-
-	}
-	return true, nil
-}